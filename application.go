@@ -11,6 +11,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"math/rand"
@@ -24,7 +25,15 @@ import (
 	"github.com/mpkondrashin/ddan"
 )
 
-var ErrInadmissibleFiles = errors.New("inadmissible files")
+var (
+	ErrInadmissibleFiles = errors.New("inadmissible files")
+	ErrNotFound          = errors.New("not found by Analyzer")
+	ErrUnexpectedStatus  = errors.New("unexpected status")
+	ErrNoReport          = errors.New("no report")
+	ErrScanTimeout       = errors.New("scan timeout")
+	ErrInterrupted       = errors.New("scan interrupted")
+	ErrSourceFailed      = errors.New("failed to scan")
+)
 
 var VerdictList = [...]string{
 	"highRisk",
@@ -34,22 +43,49 @@ var VerdictList = [...]string{
 	"unscannable",
 	"timeout",
 	"bigFile",
+	"unknown",
 }
 
+// VerdictNoRisk - verdict for files that Analyzer found no risk in
+const VerdictNoRisk = "noRisk"
+
 type Application struct {
 	analyzer     ddan.ClientInterace
 	maxFileSize  int
 	prescanJobs  int
+	fileRetries  int
 	submitJobs   int
+	hashOnly     bool
 	filter       *Filter
+	extractor    *Extractor
 	prescan      chan *File
 	prescanWg    sync.WaitGroup
+	check        chan *File
+	checkWg      sync.WaitGroup
+	checkBatch   int
+	checkWindow  time.Duration
 	submit       chan *File
 	submitWg     sync.WaitGroup
+	waitWg       sync.WaitGroup
+	poller       *Poller
+	pollBatch    int
 	returnCode   int32
 	pullInterval time.Duration
+	waitTimeout  time.Duration
 	accept       map[string]bool
 	skipFolders  []string
+	found        int
+	seen         map[string]bool
+	report       *Report
+	samplesMx    sync.Mutex
+	samples      map[string]*sample
+}
+
+// sample - files with the same SHA1. Only first of them is checked
+type sample struct {
+	first      *File
+	duplicates []*File
+	done       bool
 }
 
 func (a *Application) String() string {
@@ -63,9 +99,14 @@ func NewApplication(analyzer ddan.ClientInterace) *Application {
 		analyzer:     analyzer,
 		maxFileSize:  50_000_000,
 		prescan:      make(chan *File),
+		check:        make(chan *File),
+		checkBatch:   100,
+		checkWindow:  time.Second,
 		submit:       make(chan *File),
 		pullInterval: 60 * time.Second,
 		accept:       make(map[string]bool),
+		samples:      make(map[string]*sample),
+		seen:         make(map[string]bool),
 	}
 }
 
@@ -75,12 +116,43 @@ func (a *Application) SetPause(pullInterval time.Duration) *Application {
 	return a
 }
 
+// SetCheckBatch - set maximum number of files to check for duplicates at once and
+// maximum time to wait for batch to fill up
+func (a *Application) SetCheckBatch(checkBatch int, checkWindow time.Duration) *Application {
+	if checkBatch > 0 {
+		a.checkBatch = checkBatch
+	}
+	if checkWindow > 0 {
+		a.checkWindow = checkWindow
+	}
+	return a
+}
+
+// SetPollBatch - set maximum number of samples to request results for at once
+func (a *Application) SetPollBatch(pollBatch int) *Application {
+	a.pollBatch = pollBatch
+	return a
+}
+
+// SetWaitTimeout - set maximum time to wait for result for single file
+func (a *Application) SetWaitTimeout(waitTimeout time.Duration) *Application {
+	a.waitTimeout = waitTimeout
+	return a
+}
+
 // SetAction - set action to giver risk level
 func (a *Application) SetAction(riskLevel string, pass bool) *Application {
 	a.accept[riskLevel] = pass
 	return a
 }
 
+// SetHashOnly - check files only by SHA1 without uploading unknown files to Analyzer.
+// Such files get unknown verdict
+func (a *Application) SetHashOnly(hashOnly bool) *Application {
+	a.hashOnly = hashOnly
+	return a
+}
+
 // SetPrescanJobs - number of goroutines to implement prescan
 func (a *Application) SetPrescanJobs(jobs int) *Application {
 	a.prescanJobs = jobs
@@ -93,6 +165,12 @@ func (a *Application) SetSubmitJobs(jobs int) *Application {
 	return a
 }
 
+// SetFileRetries - number of times to retry checking of file after error
+func (a *Application) SetFileRetries(retries int) *Application {
+	a.fileRetries = retries
+	return a
+}
+
 // SetMaxFileSize - set maximum file size to submit to analyzer
 func (a *Application) SetMaxFileSize(maxFileSize int) *Application {
 	a.maxFileSize = maxFileSize
@@ -105,22 +183,34 @@ func (a *Application) SetFilter(filter *Filter) *Application {
 	return a
 }
 
+// SetExtractor - extract archives and check their members instead of archives themselves
+func (a *Application) SetExtractor(extractor *Extractor) *Application {
+	a.extractor = extractor
+	return a
+}
+
 // SetSkipFolders - set list of folders to skip
 func (a *Application) SetSkipFolders(skipFolders []string) *Application {
 	a.skipFolders = skipFolders
 	return a
 }
 
+// SetReport - set Report to collect results for each file
+func (a *Application) SetReport(report *Report) *Application {
+	a.report = report
+	return a
+}
+
 // IncReturnCode - increment number of malicious files by 1
 func (a *Application) IncReturnCode() {
 	_ = atomic.AddInt32(&a.returnCode, 1)
 }
 
 // Run - execute all operations
-func (a *Application) Run(folder string) error {
+func (a *Application) Run(ctx context.Context, sources ...Source) error {
 	startTime := time.Now()
 	log.Print(a)
-	err := a.analyzer.Register(context.TODO())
+	err := a.analyzer.Register(ctx)
 	if err != nil {
 		if !errors.Is(err, ddan.ErrAlreadyRegistered) {
 			return fmt.Errorf("analyzer register: %w", err)
@@ -128,65 +218,160 @@ func (a *Application) Run(folder string) error {
 	} else {
 		log.Print("Registration complete")
 	}
-	a.StartDispatchers()
-	err = a.WalkFolder(folder)
-	if err != nil {
-		log.Fatal(err)
-	}
+	pollerCtx, stopPoller := context.WithCancel(ctx)
+	a.poller = NewPoller(a.analyzer, a.pullInterval).SetBatchSize(a.pollBatch)
+	go a.poller.Run(pollerCtx)
+	a.StartDispatchers(ctx)
+	walkErr := a.Scan(ctx, sources)
 	close(a.prescan)
 	a.prescanWg.Wait()
+	close(a.check)
+	a.checkWg.Wait()
 	close(a.submit)
 	a.submitWg.Wait()
+	a.waitWg.Wait()
+	stopPoller()
+	if a.extractor != nil {
+		if err := a.extractor.Close(); err != nil {
+			log.Printf("Remove extracted files: %v", err)
+		}
+	}
+	for _, source := range sources {
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Close %v: %v", source, err)
+			}
+		}
+	}
 	duration := time.Since(startTime)
 	log.Printf("Operation time: %v", duration.Round(time.Second))
+	if a.report != nil {
+		err = a.report.Save()
+		if err != nil {
+			return fmt.Errorf("save report: %w", err)
+		}
+	}
+	if walkErr != nil {
+		return walkErr
+	}
 	if a.returnCode > 0 {
 		return fmt.Errorf("Found %d %w", a.returnCode, ErrInadmissibleFiles) //nolint
 	}
 	return nil
 }
 
+// Scan - pass files of all sources to prescan. Failed source does not stop
+// scan of other sources
+func (a *Application) Scan(ctx context.Context, sources []Source) error {
+	failed := 0
+	for _, source := range sources {
+		if err := source.Scan(ctx, a); err != nil {
+			if ContextError(ctx) != nil {
+				return err
+			}
+			log.Printf("ERROR: %v", err)
+			failed++
+		}
+	}
+	log.Printf("Scan complete. Found %d files. Waiting for analysis results", a.found)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sources: %w", failed, len(sources), ErrSourceFailed)
+	}
+	return nil
+}
+
 // WalkFolder - recursively process all files in given folders
-func (a *Application) WalkFolder(folder string) error {
+func (a *Application) WalkFolder(ctx context.Context, folder string) error {
 	log.Printf("Process folder: %s", folder)
-	count := 0
-	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+	info, err := os.Lstat(folder)
+	if err != nil {
+		return fmt.Errorf("processing %s folder: %w", folder, err)
+	}
+	if !info.IsDir() {
+		a.AddFile(NewFileWithInfo(folder, info))
+		return nil
+	}
+	return a.WalkFS(ctx, DirFS(folder), folder)
+}
+
+// WalkFS - recursively process all files of file system. Files paths are prefixed with given name
+func (a *Application) WalkFS(ctx context.Context, fsys fs.FS, name string) error {
+	err := fs.WalkDir(fsys, ".", func(fileName string, d fs.DirEntry, err error) error {
+		if ctxErr := ContextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		path := filepath.Join(name, filepath.FromSlash(fileName))
 		if err != nil {
-			return err
+			if fileName == "." {
+				return err
+			}
+			a.Fail(NewFSFile(fsys, fileName, path, nil), err)
+			return nil
 		}
-		switch {
-		case info.Mode()&os.ModeDir != 0:
+		if d.IsDir() {
 			if a.ShouldSkipFolder(path) {
-				return filepath.SkipDir
+				return fs.SkipDir
 			}
 			return nil
-		case info.Mode()&os.ModeSymlink != 0:
-			log.Printf("Ignore symlink file: %s", path)
-			return nil
-		case info.Mode()&(os.ModeDevice|fs.ModeCharDevice) != 0:
-			log.Printf("Ignore device file: %s", path)
-			return nil
-		case info.Mode()&os.ModeNamedPipe != 0:
-			log.Printf("Ignore named pipe file: %s", path)
-			return nil
-		case info.Mode()&os.ModeSocket != 0:
-			log.Printf("Ignore socket file: %s", path)
-			return nil
-		case info.Mode()&os.ModeIrregular != 0:
-			log.Printf("Ignore irregular file: %s", path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			a.Fail(NewFSFile(fsys, fileName, path, nil), err)
 			return nil
 		}
-		count++
-		file := NewFileWithInfo(path, info)
-		a.prescan <- file
+		a.AddFile(NewFSFile(fsys, fileName, path, info))
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("processing %s folder: %w", folder, err)
+		return fmt.Errorf("processing %s folder: %w", name, err)
 	}
-	log.Printf("Scan complete. Found %d files. Waiting for analysis results", count)
 	return nil
 }
 
+// AddFile - pass regular file to prescan. Special files and local files that
+// were already added by other source are ignored
+func (a *Application) AddFile(file *File) {
+	if key := file.LocalPath(); key != "" {
+		key = filepath.Clean(key)
+		if a.seen[key] {
+			return
+		}
+		a.seen[key] = true
+	}
+	path, info := file.Path, file.Info
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		log.Printf("Ignore symlink file: %s", path)
+		return
+	case info.Mode()&(os.ModeDevice|fs.ModeCharDevice) != 0:
+		log.Printf("Ignore device file: %s", path)
+		return
+	case info.Mode()&os.ModeNamedPipe != 0:
+		log.Printf("Ignore named pipe file: %s", path)
+		return
+	case info.Mode()&os.ModeSocket != 0:
+		log.Printf("Ignore socket file: %s", path)
+		return
+	case info.Mode()&os.ModeIrregular != 0:
+		log.Printf("Ignore irregular file: %s", path)
+		return
+	}
+	a.found++
+	a.prescan <- file
+}
+
+// ContextError - return reason of the scan context cancellation
+func ContextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrScanTimeout
+	default:
+		return ErrInterrupted
+	}
+}
+
 // ShouldSkipFolder - folders to skip at all
 func (a *Application) ShouldSkipFolder(folder string) bool {
 	for _, each := range a.skipFolders {
@@ -197,12 +382,14 @@ func (a *Application) ShouldSkipFolder(folder string) bool {
 	return false
 }
 
-// StartDispatchers - run submission and prescan dispatchers
-func (a *Application) StartDispatchers() {
+// StartDispatchers - run submission, duplicates check and prescan dispatchers
+func (a *Application) StartDispatchers(ctx context.Context) {
 	a.submitWg.Add(a.submitJobs)
 	for i := 0; i < a.submitJobs; i++ {
-		go a.SubmissionDispatcher()
+		go a.SubmissionDispatcher(ctx)
 	}
+	a.checkWg.Add(1)
+	go a.DuplicatesDispatcher(ctx)
 	a.prescanWg.Add(a.prescanJobs)
 	for i := 0; i < a.prescanJobs; i++ {
 		go a.PrescanDispatcher()
@@ -213,147 +400,446 @@ func (a *Application) StartDispatchers() {
 func (a *Application) PrescanDispatcher() {
 	defer a.prescanWg.Done()
 	for file := range a.prescan {
-		a.PrescanFile(file)
+		if err := a.PrescanFile(file); err != nil {
+			a.Fail(file, err)
+		}
 	}
 }
 
-// PrescanFile - preliminary file checks
-func (a *Application) PrescanFile(file *File) {
+// PrescanFile - preliminary file checks. Archives that pass filter and maximum
+// file size are extracted if extractor is set
+func (a *Application) PrescanFile(file *File) error {
+	admitted, err := a.admitFile(file)
+	if err != nil || !admitted {
+		return err
+	}
+	if a.extractor != nil && a.extractor.maxDepth > 0 {
+		format, err := a.extractor.Format(file)
+		if err != nil {
+			return err
+		}
+		if format != "" {
+			return a.ExtractArchive(file, 1, a.extractor.newLimits())
+		}
+	}
+	return a.submitFile(file)
+}
+
+// ExtractArchive - check members of archive and nested archives up to maximum depth
+func (a *Application) ExtractArchive(file *File, depth int, limits *extractLimits) error {
+	log.Printf("Extract: %v", file)
+	err := a.extractor.Extract(file, limits, func(member *File) error {
+		admitted, err := a.admitFile(member)
+		if err != nil {
+			a.Fail(member, err)
+			return nil
+		}
+		if !admitted {
+			return nil
+		}
+		if depth < a.extractor.maxDepth {
+			format, err := a.extractor.Format(member)
+			if err != nil {
+				a.Fail(member, err)
+				return nil
+			}
+			if format != "" {
+				err := a.ExtractArchive(member, depth+1, limits)
+				if err != nil {
+					a.Fail(member, err)
+					if errors.Is(err, ErrExtractLimit) {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		if err := a.submitFile(member); err != nil {
+			a.Fail(member, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("extract: %w", err)
+	}
+	file.Filter = FilterExtract
+	file.Pass = true
+	a.Finish(file)
+	return nil
+}
+
+// admitFile - apply filter and maximum file size. Returns false for finished
+// files that should not be checked
+func (a *Application) admitFile(file *File) (bool, error) {
 	if a.filter != nil {
 		submit, err := a.filter.CheckFile(file)
 		if err != nil {
-			log.Fatal(err)
+			return false, fmt.Errorf("filter: %w", err)
 		}
 		if !submit {
 			log.Printf("Ignore: %v", file)
-			return
+			file.Filter = FilterSkip
+			file.Pass = true
+			a.Finish(file)
+			return false, nil
 		}
+		file.Filter = FilterSubmit
 	}
 	if file.Info.Size() > int64(a.maxFileSize) {
-		if !a.accept["bigFile"] {
+		file.Verdict = "bigFile"
+		file.Pass = a.accept["bigFile"]
+		if !file.Pass {
 			log.Printf("Too big (%d) bytes file: %v", file.Info.Size(), file)
-			a.IncReturnCode()
 		} else {
 			log.Printf("Skip %d bytes file: %v", file.Info.Size(), file)
 		}
+		a.Finish(file)
+		return false, nil
+	}
+	return true, nil
+}
+
+// submitFile - pass file to duplicates check unless file with the same SHA1 is
+// already checked
+func (a *Application) submitFile(file *File) error {
+	if _, err := file.Sha1(); err != nil {
+		return err
+	}
+	if !a.Deduplicate(file) {
+		return nil
+	}
+	a.check <- file
+	return nil
+}
+
+// Deduplicate - return true if file is first one with its SHA1. Otherwise file
+// gets the same result as the first one
+func (a *Application) Deduplicate(file *File) bool {
+	a.samplesMx.Lock()
+	s, found := a.samples[file.sha1]
+	if !found {
+		a.samples[file.sha1] = &sample{first: file}
+		a.samplesMx.Unlock()
+		return true
+	}
+	if !s.done {
+		s.duplicates = append(s.duplicates, file)
+		a.samplesMx.Unlock()
+		return false
+	}
+	a.samplesMx.Unlock()
+	a.finishDuplicate(s.first, file)
+	return false
+}
+
+// Finish - account final result for file and all files with the same SHA1
+func (a *Application) Finish(file *File) {
+	a.account(file)
+	if file.sha1 == "" {
 		return
 	}
-	a.submit <- file
+	a.samplesMx.Lock()
+	s, found := a.samples[file.sha1]
+	if !found || s.first != file {
+		a.samplesMx.Unlock()
+		return
+	}
+	s.done = true
+	duplicates := s.duplicates
+	s.duplicates = nil
+	a.samplesMx.Unlock()
+	for _, duplicate := range duplicates {
+		a.finishDuplicate(file, duplicate)
+	}
+}
+
+func (a *Application) finishDuplicate(first, duplicate *File) {
+	log.Printf("Same as %s: %v", first.Path, duplicate)
+	duplicate.CopyResult(first)
+	a.account(duplicate)
+}
+
+func (a *Application) account(file *File) {
+	if !file.Pass {
+		a.IncReturnCode()
+	}
+	if a.report != nil {
+		a.report.Add(file)
+	}
+	file.Release()
+}
+
+// Fail - account error that prevented file check
+func (a *Application) Fail(file *File, err error) {
+	log.Printf("ERROR: %s: %v", file.Path, err)
+	file.Err = err
+	file.Verdict = "error"
+	file.Pass = a.accept["error"]
+	a.Finish(file)
+}
+
+// Unknown - set verdict for file that is not known to Analyzer in hash only mode
+func (a *Application) Unknown(file *File) {
+	log.Printf("Unknown: %v", file)
+	file.Verdict = "unknown"
+	file.Pass = a.accept["unknown"]
 }
 
-// SubmissionDispatcher - process files in submit channel
-func (a *Application) SubmissionDispatcher() {
+// Timeout - set verdict for file which check did not complete in time
+func (a *Application) Timeout(file *File) {
+	log.Printf("Timeout: %v", file)
+	file.Verdict = "timeout"
+	file.Pass = a.accept["timeout"]
+}
+
+// DuplicatesDispatcher - collect files from check channel into batches and
+// check which of them are already known to Analyzer
+func (a *Application) DuplicatesDispatcher(ctx context.Context) {
+	defer a.checkWg.Done()
+	var batch []*File
+	var window <-chan time.Time
+	for {
+		select {
+		case file, ok := <-a.check:
+			if !ok {
+				a.CheckDuplicates(ctx, batch)
+				return
+			}
+			batch = append(batch, file)
+			if len(batch) == 1 {
+				window = time.After(a.checkWindow)
+			}
+			if len(batch) < a.checkBatch {
+				continue
+			}
+		case <-window:
+		}
+		a.CheckDuplicates(ctx, batch)
+		batch = nil
+		window = nil
+	}
+}
+
+// CheckDuplicates - check batch of files at once and pass files unknown to Analyzer
+// to submission and known files to waiting for result
+func (a *Application) CheckDuplicates(ctx context.Context, batch []*File) {
+	if len(batch) == 0 {
+		return
+	}
+	sha1List := make([]string, len(batch))
+	for i, file := range batch {
+		sha1List[i] = file.sha1
+	}
+	duplicates, err := a.analyzer.CheckDuplicateSample(ctx, sha1List, 0)
+	for attempt := 1; err != nil && ctx.Err() == nil && attempt <= a.fileRetries; attempt++ {
+		log.Printf("Retry %d of %d: check duplicate sample for %d files: %v", attempt, a.fileRetries, len(batch), err)
+		if sleepErr := a.SleepShort(ctx); sleepErr != nil {
+			break
+		}
+		duplicates, err = a.analyzer.CheckDuplicateSample(ctx, sha1List, 0)
+	}
+	if err != nil {
+		for _, file := range batch {
+			a.Complete(ctx, file, fmt.Errorf("check duplicate sample: %w", err))
+		}
+		return
+	}
+	known := make(map[string]bool, len(duplicates))
+	for _, sha1 := range duplicates {
+		known[strings.ToLower(sha1)] = true
+	}
+	for _, file := range batch {
+		if known[strings.ToLower(file.sha1)] {
+			log.Printf("Already uploaded %v", file)
+			a.StartWaiting(ctx, file)
+			continue
+		}
+		if a.hashOnly {
+			a.Unknown(file)
+			a.Finish(file)
+			continue
+		}
+		a.submit <- file
+	}
+}
+
+// SubmissionDispatcher - upload files from submit channel and start waiting for results
+func (a *Application) SubmissionDispatcher(ctx context.Context) {
 	defer a.submitWg.Done()
 	for file := range a.submit {
-		if !a.CheckFile(file) {
-			a.IncReturnCode()
+		err := a.Retry(ctx, file, a.UploadFile)
+		if err != nil {
+			a.Complete(ctx, file, err)
+			continue
 		}
+		a.StartWaiting(ctx, file)
+	}
+}
+
+// StartWaiting - start waiting for result for submitted file
+func (a *Application) StartWaiting(ctx context.Context, file *File) {
+	a.waitWg.Add(1)
+	go a.WaitDispatcher(ctx, file)
+}
+
+// WaitDispatcher - wait for result for submitted file
+func (a *Application) WaitDispatcher(ctx context.Context, file *File) {
+	defer a.waitWg.Done()
+	err := a.Retry(ctx, file, func(ctx context.Context, file *File) error {
+		return a.WaitForResult(ctx, file, file.sha1)
+	})
+	a.Complete(ctx, file, err)
+}
+
+// Retry - run operation on file, retrying it after error up to fileRetries times
+func (a *Application) Retry(ctx context.Context, file *File, operation func(context.Context, *File) error) error {
+	err := operation(ctx, file)
+	for attempt := 1; err != nil && ctx.Err() == nil && attempt <= a.fileRetries; attempt++ {
+		log.Printf("Retry %d of %d: %s: %v", attempt, a.fileRetries, file.Path, err)
+		if sleepErr := a.SleepShort(ctx); sleepErr != nil {
+			break
+		}
+		err = operation(ctx, file)
+	}
+	return err
+}
+
+// Complete - account result of file check
+func (a *Application) Complete(ctx context.Context, file *File, err error) {
+	switch {
+	case err != nil && errors.Is(ContextError(ctx), ErrScanTimeout):
+		a.Timeout(file)
+		a.Finish(file)
+	case err != nil:
+		a.Fail(file, err)
+	default:
+		a.Finish(file)
 	}
 }
 
-// CheckFile - check file and return whenever it is Ok
-func (a *Application) CheckFile(file *File) bool {
+// UploadFile - upload file to Analyzer
+func (a *Application) UploadFile(ctx context.Context, file *File) error {
 	sha1, err := file.Sha1()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	sha1List := []string{sha1}
-	duplicates, err := a.analyzer.CheckDuplicateSample(context.TODO(), sha1List, 0)
+	localPath, err := file.Stage()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	if len(duplicates) == 0 || !strings.EqualFold(duplicates[0], sha1) {
-		err = a.analyzer.UploadSample(context.TODO(), file.Path, sha1)
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.Printf("Uploaded %v", file)
-	} else {
-		log.Printf("Already uploaded %v", file)
+	err = a.analyzer.UploadSample(ctx, localPath, sha1)
+	if err != nil {
+		return fmt.Errorf("upload sample: %w", err)
 	}
-	return a.WaitForResult(file, sha1)
+	log.Printf("Uploaded %v", file)
+	return nil
 }
 
 // WaitForResult - wait for result from Analyzer for file defined by sha1.
-func (a *Application) WaitForResult(file *File, sha1 string) bool {
-	for {
-		sha1List := []string{sha1}
-		briefReport, err := a.analyzer.GetBriefReport(context.TODO(), sha1List)
+// If result is not available within wait timeout, file gets timeout verdict.
+func (a *Application) WaitForResult(ctx context.Context, file *File, sha1 string) error {
+	if a.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.waitTimeout)
+		defer cancel()
+	}
+	report, err := a.poller.Wait(ctx, sha1)
+	if err != nil {
+		if errors.Is(err, ErrScanTimeout) {
+			a.Timeout(file)
+			return nil
+		}
+		return err
+	}
+	switch report.SampleStatus {
+	case ddan.StatusNotFound:
+		if a.hashOnly {
+			a.Unknown(file)
+			return nil
+		}
+		return fmt.Errorf("%s: %w", sha1, ErrNotFound)
+	case ddan.StatusError, ddan.StatusTimeout:
+		log.Printf("%v for %v", report.SampleStatus, file)
+		fallthrough
+	case ddan.StatusDone:
+		if report.RiskLevel < 0 {
+			log.Printf("ERROR: %v: %v", report.RiskLevel, file)
+		} else {
+			log.Printf("%v: %v", report.RiskLevel, file)
+		}
+		pass, err := a.Pass(report, file)
 		if err != nil {
-			log.Fatal(err)
-		}
-		report := briefReport.Reports[0]
-		switch report.SampleStatus {
-		case ddan.StatusNotFound:
-			log.Fatalf("Not found by Analyzer: %s (%v)", sha1, file)
-		case ddan.StatusArrived:
-			a.SleepLong()
-			continue
-		case ddan.StatusProcessing:
-			a.SleepShort()
-			continue
-		case ddan.StatusError, ddan.StatusTimeout:
-			log.Printf("%v for %v", report.SampleStatus, file)
-			fallthrough
-		case ddan.StatusDone:
-			if report.RiskLevel < 0 {
-				log.Printf("ERROR: %v: %v", report.RiskLevel, file)
-			} else {
-				log.Printf("%v: %v", report.RiskLevel, file)
-			}
-			return a.Pass(report, file)
-		default:
-			log.Fatalf("%s: %v: Unexpected status value: %v", sha1, file, report.SampleStatus)
+			return err
+		}
+		file.Report = &report
+		if cache, ok := a.analyzer.(CacheAgeReporter); ok {
+			file.CacheAge, _ = cache.CacheAge(sha1)
 		}
+		file.Pass = pass
+		return nil
+	default:
+		return fmt.Errorf("%s: %w: %v", sha1, ErrUnexpectedStatus, report.SampleStatus)
 	}
 }
 
-// Pass - return whenever file should be accepted to pass.
-func (a *Application) Pass(b ddan.BriefReport, file *File) bool {
+// Pass - return whenever file should be accepted to pass. Returns error
+// if analysis is not complete
+func (a *Application) Pass(b ddan.BriefReport, file *File) (bool, error) {
 	switch b.SampleStatus {
 	case ddan.StatusNotFound, ddan.StatusArrived, ddan.StatusProcessing:
-		log.Fatal(ddan.NotReadyError(ddan.StatusCodeNames[b.SampleStatus]))
+		return false, ddan.NotReadyError(ddan.StatusCodeNames[b.SampleStatus])
 	case ddan.StatusDone:
-		return a.PassForRiskLevel(b.RiskLevel)
+		file.Verdict = RiskLevelVerdict(b.RiskLevel)
 	case ddan.StatusError:
-		return a.accept["error"]
+		file.Verdict = "error"
 	case ddan.StatusTimeout:
-		return a.accept["timeout"]
+		file.Verdict = "timeout"
+	default:
+		return false, nil
 	}
-	return false
+	return a.PassVerdict(file.Verdict), nil
 }
 
-func (a *Application) PassForRiskLevel(riskLevel ddan.Rating) bool {
+// PassVerdict - return whenever files with given verdict are accepted to pass.
+func (a *Application) PassVerdict(verdict string) bool {
+	if verdict == VerdictNoRisk {
+		return true
+	}
+	return a.accept[verdict]
+}
+
+// RiskLevelVerdict - return verdict for risk level.
+func RiskLevelVerdict(riskLevel ddan.Rating) string {
 	switch riskLevel {
 	case ddan.RatingUnsupported:
-		return a.accept["unscannable"]
+		return "unscannable"
 	case ddan.RatingNoRiskFound:
-		return true
+		return VerdictNoRisk
 	case ddan.RatingLowRisk:
-		return a.accept["lowRisk"]
+		return "lowRisk"
 	case ddan.RatingMediumRisk:
-		return a.accept["mediumRisk"]
+		return "mediumRisk"
 	case ddan.RatingHighRisk:
-		return a.accept["highRisk"]
+		return "highRisk"
 	default:
-		return a.accept["error"]
+		return "error"
 	}
 }
 
-// SleepLong - sleep for long when file is still in the queue.
-func (a *Application) SleepLong() {
-	a.SleepRandom(a.pullInterval)
-}
-
 // SleepShort - sleep for short when file is alredy being processed.
-func (a *Application) SleepShort() {
-	a.SleepRandom(a.pullInterval / 4)
+func (a *Application) SleepShort(ctx context.Context) error {
+	return a.SleepRandom(ctx, a.pullInterval/4)
 }
 
-// SleepRandom - sleep for random time between d/2 and d.
-func (a *Application) SleepRandom(d time.Duration) {
+// SleepRandom - sleep for random time between d/2 and d or until context is done.
+func (a *Application) SleepRandom(ctx context.Context, d time.Duration) error {
 	duration := rand.Int63n(int64(d) / 2) //nolint
-	time.Sleep(time.Duration(duration) + d/2)
+	timer := time.NewTimer(time.Duration(duration) + d/2)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ContextError(ctx)
+	}
 }
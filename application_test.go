@@ -9,10 +9,16 @@ application_test.go - tests for Application
 package main
 
 import (
+	"context"
+	"crypto/sha1" //nolint
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,6 +26,11 @@ import (
 )
 
 func analyzerMockupClient(t *testing.T) (ddan.ClientInterace, func()) {
+	return analyzerMockup(t, ddan.NewClient("productName", "hostname"))
+}
+
+// analyzerMockup - start Analyzer mockup and setup client to use it
+func analyzerMockup(t *testing.T, client ddan.ClientInterace) (ddan.ClientInterace, func()) {
 	AnalyzerURL := "127.0.0.1:8000"
 	apiKey := "00000000-0000-0000-0000-000000000000"
 	mockup := ddan.NewMockup(AnalyzerURL, apiKey)
@@ -48,7 +59,7 @@ func analyzerMockupClient(t *testing.T) (ddan.ClientInterace, func()) {
 		}
 	}
 
-	return ddan.NewClient("productName", "hostname").
+	return client.
 		SetAnalyzer(URL, apiKey, false).
 		SetSource("500", "sourceName").
 		SetUUID("12341234-1234-1234-1234-123412341234"), stop
@@ -141,7 +152,7 @@ func TestApplication001(t *testing.T) {
 		app.SetAction(each, true)
 	}
 	app.SetAction("highRisk", false)
-	err := app.Run(baseFolder)
+	err := app.Run(context.Background(), FolderSource(baseFolder))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -168,7 +179,7 @@ func TestApplicationMaxFileSize(t *testing.T) {
 	analyzer, stop := analyzerMockupClient(t)
 	app := NewApplication(analyzer).SetPause(1 * time.Millisecond)
 	app.SetMaxFileSize(10)
-	err := app.Run(baseFolder)
+	err := app.Run(context.Background(), FolderSource(baseFolder))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -184,9 +195,353 @@ func TestApplicationBigFolder(t *testing.T) {
 		app.SetAction(each, true)
 	}
 	app.SetAction("highRisk", false)
-	err := app.Run(baseFolder)
+	err := app.Run(context.Background(), FolderSource(baseFolder))
 	if err != nil {
 		t.Fatal(err)
 	}
 	stop()
 }
+
+func TestApplicationSleepCancel(t *testing.T) {
+	app := NewApplication(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	err := app.SleepRandom(ctx, time.Hour)
+	if !errors.Is(err, ErrScanTimeout) {
+		t.Errorf("Expected %v, but got %v", ErrScanTimeout, err)
+	}
+	if time.Since(startTime) > time.Minute {
+		t.Errorf("Sleep was not interrupted")
+	}
+}
+
+type fakeClient struct {
+	ddan.ClientInterace
+	mx         sync.Mutex
+	known      map[string]bool
+	duplicates [][]string
+	uploads    []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{known: make(map[string]bool)}
+}
+
+func (c *fakeClient) Register(ctx context.Context) error {
+	return nil
+}
+
+func (c *fakeClient) CheckDuplicateSample(ctx context.Context, sha1List []string, days int) ([]string, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.duplicates = append(c.duplicates, sha1List)
+	var result []string
+	for _, sha1 := range sha1List {
+		if c.known[sha1] {
+			result = append(result, sha1)
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeClient) UploadSample(ctx context.Context, filePath, sha1 string) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.uploads = append(c.uploads, filePath)
+	c.known[sha1] = true
+	return nil
+}
+
+func (c *fakeClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	result := &ddan.BriefReports{}
+	for range sha1List {
+		result.Reports = append(result.Reports, ddan.BriefReport{
+			SampleStatus: ddan.StatusDone,
+			RiskLevel:    ddan.RatingNoRiskFound,
+		})
+	}
+	return result, nil
+}
+
+// brokenClient - fake client that fails given number of uploads of samples and
+// duplicates checks
+type brokenClient struct {
+	*fakeClient
+	uploadErrors map[string]int
+	checkErrors  int
+	attempts     map[string]int
+}
+
+func newBrokenClient() *brokenClient {
+	return &brokenClient{
+		fakeClient:   newFakeClient(),
+		uploadErrors: make(map[string]int),
+		attempts:     make(map[string]int),
+	}
+}
+
+func (c *brokenClient) CheckDuplicateSample(ctx context.Context, sha1List []string, days int) ([]string, error) {
+	c.mx.Lock()
+	if c.checkErrors > 0 {
+		c.checkErrors--
+		c.mx.Unlock()
+		return nil, errors.New("check duplicate sample failed")
+	}
+	c.mx.Unlock()
+	return c.fakeClient.CheckDuplicateSample(ctx, sha1List, days)
+}
+
+func (c *brokenClient) UploadSample(ctx context.Context, filePath, sha1 string) error {
+	c.mx.Lock()
+	c.attempts[sha1]++
+	if c.uploadErrors[sha1] > 0 {
+		c.uploadErrors[sha1]--
+		c.mx.Unlock()
+		return errors.New("upload failed")
+	}
+	c.mx.Unlock()
+	return c.fakeClient.UploadSample(ctx, filePath, sha1)
+}
+
+// sampleSha1 - SHA1 of file created by prepairFolder with given name
+func sampleSha1(name string) string {
+	sum := sha1.Sum([]byte(strings.TrimSuffix(name, filepath.Ext(name))))
+	return hex.EncodeToString(sum[:])
+}
+
+// scanCase - scan of folder prepared by prepairFolder
+type scanCase struct {
+	name    string
+	client  ddan.ClientInterace
+	setup   func(app *Application)
+	timeout time.Duration
+	err     error
+	check   func(t *testing.T, records []Record)
+}
+
+// runScanCases - scan new folder for each case and check error of the scan and
+// number of report records. Other results are checked by check function of case
+func runScanCases(t *testing.T, cases []scanCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseFolder := t.TempDir()
+			prepairFolder(t, baseFolder)
+			report := NewReport()
+			app := NewApplication(tc.client).
+				SetPause(1 * time.Millisecond).
+				SetReport(report)
+			app.SetPrescanJobs(2).SetSubmitJobs(2)
+			if tc.setup != nil {
+				tc.setup(app)
+			}
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			err := app.Run(ctx, FolderSource(baseFolder))
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected %v, but got %v", tc.err, err)
+			}
+			records := report.Records()
+			if len(records) != 8 {
+				t.Fatalf("Expected 8 records, but got %d", len(records))
+			}
+			if tc.check != nil {
+				tc.check(t, records)
+			}
+		})
+	}
+}
+
+// checkVerdicts - compare verdict of each record with expected one
+func checkVerdicts(t *testing.T, records []Record, expected func(record Record) string) {
+	t.Helper()
+	for _, record := range records {
+		if verdict := expected(record); record.Verdict != verdict {
+			t.Errorf("%s: expected %s verdict, but got %v", record.Path, verdict, record)
+		}
+	}
+}
+
+func TestApplicationFileErrors(t *testing.T) {
+	client := newBrokenClient()
+	failed := sampleSha1("high_risk.txt")
+	retried := sampleSha1("medium_risk.txt")
+	client.uploadErrors[failed] = 100
+	client.uploadErrors[retried] = 1
+	runScanCases(t, []scanCase{{
+		name:   "upload",
+		client: client,
+		setup:  func(app *Application) { app.SetFileRetries(1) },
+		err:    ErrInadmissibleFiles,
+		check: func(t *testing.T, records []Record) {
+			if client.attempts[failed] != 2 || client.attempts[retried] != 2 {
+				t.Errorf("Expected 2 upload attempts, but got %d and %d", client.attempts[failed], client.attempts[retried])
+			}
+			checkVerdicts(t, records, func(record Record) string {
+				if record.SHA1 == failed {
+					if record.Error == "" || record.Pass {
+						t.Errorf("%s: expected failed check, but got %v", record.Path, record)
+					}
+					return "error"
+				}
+				return VerdictNoRisk
+			})
+		},
+	}})
+}
+
+func TestApplicationCheckErrors(t *testing.T) {
+	var cases []scanCase
+	for _, tc := range []struct {
+		retries  int
+		verdict  string
+		expected error
+	}{
+		{0, "error", ErrInadmissibleFiles},
+		{2, VerdictNoRisk, nil},
+	} {
+		tc := tc
+		client := newBrokenClient()
+		client.checkErrors = 2
+		cases = append(cases, scanCase{
+			name:   fmt.Sprintf("%d retries", tc.retries),
+			client: client,
+			setup:  func(app *Application) { app.SetFileRetries(tc.retries) },
+			err:    tc.expected,
+			check: func(t *testing.T, records []Record) {
+				checkVerdicts(t, records, func(Record) string { return tc.verdict })
+			},
+		})
+	}
+	runScanCases(t, cases)
+}
+
+// stuckClient - fake client that never completes analysis of given samples
+type stuckClient struct {
+	*fakeClient
+	stuck map[string]bool
+}
+
+func (c *stuckClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	result := &ddan.BriefReports{}
+	for _, sha1 := range sha1List {
+		report := ddan.BriefReport{SampleStatus: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound}
+		if c.stuck == nil || c.stuck[sha1] {
+			report.SampleStatus = ddan.StatusProcessing
+		}
+		result.Reports = append(result.Reports, report)
+	}
+	return result, nil
+}
+
+func TestApplicationWaitTimeout(t *testing.T) {
+	stuck := sampleSha1("high_risk.txt")
+	runScanCases(t, []scanCase{{
+		name:   "stuck",
+		client: &stuckClient{fakeClient: newFakeClient(), stuck: map[string]bool{stuck: true}},
+		setup:  func(app *Application) { app.SetWaitTimeout(50 * time.Millisecond) },
+		err:    ErrInadmissibleFiles,
+		check: func(t *testing.T, records []Record) {
+			checkVerdicts(t, records, func(record Record) string {
+				if record.SHA1 == stuck {
+					return "timeout"
+				}
+				return VerdictNoRisk
+			})
+		},
+	}})
+}
+
+func TestApplicationScanTimeout(t *testing.T) {
+	startTime := time.Now()
+	runScanCases(t, []scanCase{{
+		name:    "stuck",
+		client:  &stuckClient{fakeClient: newFakeClient()},
+		timeout: 100 * time.Millisecond,
+		err:     ErrInadmissibleFiles,
+		check: func(t *testing.T, records []Record) {
+			checkVerdicts(t, records, func(Record) string { return "timeout" })
+			for _, record := range records {
+				if record.Pass {
+					t.Errorf("%s: timeout passed", record.Path)
+				}
+			}
+		},
+	}})
+	if time.Since(startTime) > 10*time.Second {
+		t.Errorf("Scan was not stopped in time")
+	}
+}
+
+func TestApplicationCheckBatch(t *testing.T) {
+	client := newFakeClient()
+	client.known[sampleSha1("high_risk.txt")] = true
+	runScanCases(t, []scanCase{{
+		name:   "batch",
+		client: client,
+		setup:  func(app *Application) { app.SetCheckBatch(100, time.Hour) },
+		check: func(t *testing.T, records []Record) {
+			if len(client.duplicates) != 1 {
+				t.Errorf("Expected single duplicates check, but got %d", len(client.duplicates))
+			}
+			if len(client.uploads) != 3 {
+				t.Errorf("Expected 3 uploads, but got %d", len(client.uploads))
+			}
+		},
+	}})
+}
+
+func TestApplicationHashOnly(t *testing.T) {
+	client := newFakeClient()
+	known := sampleSha1("high_risk.txt")
+	client.known[known] = true
+	runScanCases(t, []scanCase{{
+		name:   "known",
+		client: client,
+		setup: func(app *Application) {
+			app.SetHashOnly(true)
+			app.SetAction("unknown", true)
+		},
+		check: func(t *testing.T, records []Record) {
+			if len(client.uploads) != 0 {
+				t.Errorf("Expected no uploads, but got %v", client.uploads)
+			}
+			checkVerdicts(t, records, func(record Record) string {
+				if !record.Pass {
+					t.Errorf("%s: not passed", record.Path)
+				}
+				if record.SHA1 == known {
+					return VerdictNoRisk
+				}
+				return "unknown"
+			})
+		},
+	}})
+}
+
+func TestApplicationDeduplicate(t *testing.T) {
+	client := newFakeClient()
+	runScanCases(t, []scanCase{{
+		name:   "same content",
+		client: client,
+		check: func(t *testing.T, records []Record) {
+			if len(client.uploads) != 4 {
+				t.Errorf("Expected 4 uploads, but got %d", len(client.uploads))
+			}
+			samples := Samples(records)
+			if len(samples) != 4 {
+				t.Fatalf("Expected 4 samples, but got %d", len(samples))
+			}
+			for _, each := range samples {
+				if len(each.Paths) != 2 {
+					t.Errorf("%s: expected 2 paths, but got %v", each.SHA1, each.Paths)
+				}
+			}
+		},
+	}})
+}
@@ -0,0 +1,492 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+cacheadmin.go - cache administration commands
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrCacheAdmin       = errors.New("cache administration is not supported")
+	ErrUnknownRiskLevel = errors.New("unknown risk level")
+)
+
+// cacheCommand - cia cache subcommand
+type cacheCommand struct {
+	name        string
+	usage       string
+	description string
+	run         func(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error
+	setup       func(flags *pflag.FlagSet)
+}
+
+var cacheCommands []cacheCommand
+
+func init() {
+	cacheCommands = []cacheCommand{
+		{"list", "", "list cached samples", cacheList, nil},
+		{"show", "sha1...", "show cached verdict for samples", cacheShow, nil},
+		{"set-verdict", "--risk-level level --note text sha1...", "set verdict for samples manually", cacheSetVerdict,
+			func(flags *pflag.FlagSet) {
+				flags.String("risk-level", "", "noRisk, lowRisk, mediumRisk, highRisk, unscannable or number")
+				flags.String("note", "", "reason of manual verdict (required)")
+				flags.String("author", "", "author of manual verdict (default - current user)")
+			}},
+		{"delete", "[--note text] sha1...", "delete samples from cache", cacheDelete,
+			func(flags *pflag.FlagSet) {
+				flags.String("note", "", "reason of deletion for audit log")
+				flags.String("author", "", "author of deletion (default - current user)")
+			}},
+		{"purge", "--older-than age", "delete verdicts older than given age, e.g. 30d", cachePurge,
+			func(flags *pflag.FlagSet) {
+				flags.String("older-than", "", "age of verdicts to delete: 12h, 30d, 2w")
+				flags.Bool("keep-manual", false, "do not delete manually set verdicts")
+				flags.String("note", "", "reason of purge for audit log")
+				flags.String("author", "", "author of purge (default - current user)")
+			}},
+		{"stats", "", "show cache statistics", cacheStats, nil},
+		{"export", "[--output file]", "export cache as JSON lines", cacheExport,
+			func(flags *pflag.FlagSet) {
+				flags.String("output", "-", "output file. \"-\" for stdout")
+			}},
+		{"import", "[--input file] [--note text]", "import JSON lines exported by export command", cacheImport,
+			func(flags *pflag.FlagSet) {
+				flags.String("input", "-", "input file. \"-\" for stdin")
+				flags.String("note", "", "reason of import for audit log")
+				flags.String("author", "", "author of import (default - current user)")
+			}},
+	}
+}
+
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w:\n%s", ErrUsage, cacheUsage())
+	}
+	if args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(cacheUsage())
+		return nil
+	}
+	if args[0] == "check" {
+		return runCacheCheck(args[1:])
+	}
+	for _, command := range cacheCommands {
+		if command.name != args[0] {
+			continue
+		}
+		flags, configFile := newFlagSet("cache " + command.name)
+		if command.setup != nil {
+			command.setup(flags)
+		}
+		if err := parseFlags(flags, configFile, args[1:], nil); err != nil {
+			return err
+		}
+		cache, err := openAdminCache()
+		if err != nil {
+			return err
+		}
+		err = command.run(cache, flags, os.Stdout)
+		if closeErr := cache.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return fmt.Errorf("%w:\n%s", ErrUsage, cacheUsage())
+}
+
+// cacheUsage - list of cache subcommands
+func cacheUsage() string {
+	var usage strings.Builder
+	usage.WriteString("cia cache command [options]\n  check - check connection to cache\n")
+	for _, command := range cacheCommands {
+		fmt.Fprintf(&usage, "  %s %s - %s\n", command.name, command.usage, command.description)
+	}
+	return usage.String()
+}
+
+func runCacheCheck(args []string) error {
+	flags, configFile := newFlagSet("cache check")
+	if err := parseFlags(flags, configFile, args, nil); err != nil {
+		return err
+	}
+	verdictCache, err := setupVerdictCache()
+	if err != nil {
+		return err
+	}
+	if verdictCache == nil {
+		return errors.New("cache is not configured")
+	}
+	fmt.Printf("Cache %s is available\n", viper.GetString("cache.type"))
+	return verdictCache.Close()
+}
+
+// openAdminCache - open configured cache. Memory cache can not be administered
+func openAdminCache() (VerdictCache, error) {
+	cacheType := viper.GetString("cache.type")
+	switch cacheType {
+	case "":
+		return nil, errors.New("cache is not configured")
+	case "memory":
+		return nil, fmt.Errorf("%w for memory cache: it exists only during the scan", ErrCacheAdmin)
+	}
+	cache, err := setupVerdictCache()
+	if err != nil {
+		return nil, err
+	}
+	if cache == nil {
+		return nil, errors.New("cache is not configured")
+	}
+	return cache, nil
+}
+
+func cacheList(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	verdicts, err := sortedVerdicts(cache)
+	if err != nil {
+		return err
+	}
+	for _, verdict := range verdicts {
+		fmt.Fprintln(output, formatVerdict(verdict))
+	}
+	return nil
+}
+
+func cacheShow(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no SHA1 given", ErrUsage)
+	}
+	for _, sha1 := range flags.Args() {
+		verdict, found, err := cache.Get(strings.ToLower(sha1))
+		if err != nil {
+			return err
+		}
+		if !found {
+			fmt.Fprintf(output, "%s not found\n", sha1)
+			continue
+		}
+		fmt.Fprintln(output, formatVerdict(verdict))
+	}
+	return nil
+}
+
+func cacheSetVerdict(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no SHA1 given", ErrUsage)
+	}
+	riskLevelName, _ := flags.GetString("risk-level")
+	riskLevel, err := ParseRiskLevel(riskLevelName)
+	if err != nil {
+		return err
+	}
+	note, _ := flags.GetString("note")
+	if strings.TrimSpace(note) == "" {
+		return fmt.Errorf("%w: --note is required for manual verdict", ErrUsage)
+	}
+	author := commandAuthor(flags)
+	var changed []string
+	for _, sha1 := range flags.Args() {
+		verdict := Verdict{
+			SHA1:      strings.ToLower(sha1),
+			Status:    ddan.StatusDone,
+			RiskLevel: riskLevel,
+			Time:      time.Now().UTC(),
+			Author:    author,
+			Note:      note,
+		}
+		if err := cache.Put(verdict); err != nil {
+			return auditFailure(err, "set-verdict "+RiskLevelVerdict(riskLevel), author, note, changed)
+		}
+		changed = append(changed, verdict.SHA1)
+		fmt.Fprintln(output, formatVerdict(verdict))
+	}
+	return writeAudit("set-verdict "+RiskLevelVerdict(riskLevel), author, note, changed)
+}
+
+func cacheDelete(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no SHA1 given", ErrUsage)
+	}
+	note, _ := flags.GetString("note")
+	author := commandAuthor(flags)
+	var deleted []string
+	for _, sha1 := range flags.Args() {
+		sha1 = strings.ToLower(sha1)
+		_, found, err := cache.Get(sha1)
+		if err != nil {
+			return auditFailure(err, "delete", author, note, deleted)
+		}
+		if !found {
+			fmt.Fprintf(output, "%s not found\n", sha1)
+			continue
+		}
+		if err := cache.Delete(sha1); err != nil {
+			return auditFailure(err, "delete", author, note, deleted)
+		}
+		deleted = append(deleted, sha1)
+	}
+	fmt.Fprintf(output, "Deleted %d samples\n", len(deleted))
+	return writeAudit("delete", author, note, deleted)
+}
+
+func cachePurge(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	olderThan, _ := flags.GetString("older-than")
+	if olderThan == "" {
+		return fmt.Errorf("%w: --older-than is required", ErrUsage)
+	}
+	age, err := ParseAge(olderThan)
+	if err != nil {
+		return err
+	}
+	keepManual, _ := flags.GetBool("keep-manual")
+	threshold := time.Now().Add(-time.Duration(age))
+	var expired []string
+	err = cache.Range(func(verdict Verdict) error {
+		if verdict.Time.Before(threshold) && !(keepManual && verdict.Manual()) {
+			expired = append(expired, verdict.SHA1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	note, _ := flags.GetString("note")
+	author := commandAuthor(flags)
+	action := "purge --older-than " + olderThan
+	for i, sha1 := range expired {
+		if err := cache.Delete(sha1); err != nil {
+			return auditFailure(err, action, author, note, expired[:i])
+		}
+	}
+	fmt.Fprintf(output, "Purged %d samples\n", len(expired))
+	return writeAudit(action, author, note, expired)
+}
+
+// auditEntry - record of cache change made by administration command
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author"`
+	Action string    `json:"action"`
+	Note   string    `json:"note,omitempty"`
+	SHA1   []string  `json:"sha1"`
+}
+
+// writeAudit - append entry to cache.auditLog JSON lines file. Nothing is
+// written if no samples were changed
+func writeAudit(action, author, note string, sha1List []string) error {
+	if len(sha1List) == 0 {
+		return nil
+	}
+	viper.SetDefault("cache.auditLog", "cia_cache_audit.jsonl")
+	path := viper.GetString("cache.auditLog")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	err = json.NewEncoder(file).Encode(auditEntry{
+		Time:   time.Now().UTC(),
+		Author: author,
+		Action: action,
+		Note:   note,
+		SHA1:   sha1List,
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("audit log %s: %w", path, err)
+	}
+	return nil
+}
+
+// auditFailure - write audit entry for samples changed before command failed
+func auditFailure(err error, action, author, note string, sha1List []string) error {
+	if auditErr := writeAudit(action, author, note, sha1List); auditErr != nil {
+		log.Printf("ERROR: %v", auditErr)
+	}
+	return err
+}
+
+// commandAuthor - return --author flag value or current user
+func commandAuthor(flags *pflag.FlagSet) string {
+	if author, _ := flags.GetString("author"); author != "" {
+		return author
+	}
+	return currentUser()
+}
+
+func cacheStats(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	total, manual := 0, 0
+	byVerdict := make(map[string]int)
+	var oldest, newest time.Time
+	err := cache.Range(func(verdict Verdict) error {
+		total++
+		if verdict.Manual() {
+			manual++
+		}
+		byVerdict[RiskLevelVerdict(verdict.RiskLevel)]++
+		if verdict.Time.IsZero() {
+			return nil
+		}
+		if oldest.IsZero() || verdict.Time.Before(oldest) {
+			oldest = verdict.Time
+		}
+		if verdict.Time.After(newest) {
+			newest = verdict.Time
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Samples: %d\n", total)
+	fmt.Fprintf(output, "Manual verdicts: %d\n", manual)
+	names := make([]string, 0, len(byVerdict))
+	for name := range byVerdict {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(output, "%s: %d\n", name, byVerdict[name])
+	}
+	if !oldest.IsZero() {
+		fmt.Fprintf(output, "Oldest: %s\n", oldest.Format(time.RFC3339))
+		fmt.Fprintf(output, "Newest: %s\n", newest.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func cacheExport(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	path, _ := flags.GetString("output")
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	verdicts, err := sortedVerdicts(cache)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(output)
+	for _, verdict := range verdicts {
+		if err := encoder.Encode(verdict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheImport(cache VerdictCache, flags *pflag.FlagSet, output io.Writer) error {
+	path, _ := flags.GetString("input")
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	note, _ := flags.GetString("note")
+	author := commandAuthor(flags)
+	imported, err := importVerdicts(cache, input)
+	if err != nil {
+		return auditFailure(err, "import", author, note, imported)
+	}
+	fmt.Fprintf(output, "Imported %d samples\n", len(imported))
+	return writeAudit("import", author, note, imported)
+}
+
+// importVerdicts - put verdicts from JSON lines to cache and return SHA1 of
+// imported samples
+func importVerdicts(cache VerdictCache, input io.Reader) ([]string, error) {
+	var imported []string
+	scanner := bufio.NewScanner(input)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var verdict Verdict
+		if err := json.Unmarshal(scanner.Bytes(), &verdict); err != nil {
+			return imported, fmt.Errorf("line %d: %w", line, err)
+		}
+		if verdict.SHA1 == "" {
+			return imported, fmt.Errorf("line %d: sha1 is missing", line)
+		}
+		verdict.SHA1 = strings.ToLower(verdict.SHA1)
+		if err := cache.Put(verdict); err != nil {
+			return imported, err
+		}
+		imported = append(imported, verdict.SHA1)
+	}
+	return imported, scanner.Err()
+}
+
+func sortedVerdicts(cache VerdictCache) ([]Verdict, error) {
+	var verdicts []Verdict
+	err := cache.Range(func(verdict Verdict) error {
+		verdicts = append(verdicts, verdict)
+		return nil
+	})
+	sort.Slice(verdicts, func(i, j int) bool {
+		return verdicts[i].SHA1 < verdicts[j].SHA1
+	})
+	return verdicts, err
+}
+
+func formatVerdict(verdict Verdict) string {
+	s := fmt.Sprintf("%s %s %s %s", verdict.SHA1, verdict.Status, RiskLevelVerdict(verdict.RiskLevel),
+		verdict.Time.Format(time.RFC3339))
+	if verdict.Manual() {
+		s += fmt.Sprintf(" manual by %s: %s", verdict.Author, verdict.Note)
+	}
+	return s
+}
+
+// ParseRiskLevel - parse risk level given by verdict name or number
+func ParseRiskLevel(value string) (ddan.Rating, error) {
+	for _, riskLevel := range []ddan.Rating{
+		ddan.RatingUnsupported,
+		ddan.RatingNoRiskFound,
+		ddan.RatingLowRisk,
+		ddan.RatingMediumRisk,
+		ddan.RatingHighRisk,
+	} {
+		if strings.EqualFold(value, RiskLevelVerdict(riskLevel)) {
+			return riskLevel, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", value, ErrUnknownRiskLevel)
+	}
+	return ddan.Rating(number), nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
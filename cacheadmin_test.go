@@ -0,0 +1,197 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+cacheadmin_test.go - tests for cache administration commands
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func runCacheCommand(t *testing.T, cache VerdictCache, name string, args ...string) (string, error) {
+	t.Helper()
+	for _, command := range cacheCommands {
+		if command.name != name {
+			continue
+		}
+		flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+		if command.setup != nil {
+			command.setup(flags)
+		}
+		if err := flags.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		err := command.run(cache, flags, &output)
+		return output.String(), err
+	}
+	t.Fatalf("Unknown command %s", name)
+	return "", nil
+}
+
+func TestCacheAdmin(t *testing.T) {
+	cache, err := OpenFileCache(filepath.Join(t.TempDir(), "cache.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	testCacheAdmin(t, cache)
+}
+
+func testCacheAdmin(t *testing.T, cache VerdictCache) {
+	t.Helper()
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	viper.Set("cache.auditLog", auditLog)
+	defer viper.Reset()
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	for _, verdict := range []Verdict{
+		{SHA1: "aaa", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk, Time: time.Now()},
+		{SHA1: "bbb", Status: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound, Time: old},
+		{SHA1: "ccc", Status: ddan.StatusDone, RiskLevel: ddan.RatingLowRisk, Time: old},
+	} {
+		if err := cache.Put(verdict); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := runCacheCommand(t, cache, "set-verdict", "--risk-level", "noRisk", "AAA"); !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected %v for missing note, but got %v", ErrUsage, err)
+	}
+	if _, err := runCacheCommand(t, cache, "set-verdict", "--risk-level", "noRisk", "--note", "false positive",
+		"--author", "admin", "AAA"); err != nil {
+		t.Fatal(err)
+	}
+	verdict, _, _ := cache.Get("aaa")
+	if verdict.RiskLevel != ddan.RatingNoRiskFound || verdict.Note != "false positive" || verdict.Author != "admin" {
+		t.Errorf("Wrong manual verdict: %v", verdict)
+	}
+	verdict.Time = old
+	if err := cache.Put(verdict); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runCacheCommand(t, cache, "show", "aaa", "ddd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "manual by admin: false positive") || !strings.Contains(output, "ddd not found") {
+		t.Errorf("Wrong show output: %s", output)
+	}
+
+	exported, err := runCacheCommand(t, cache, "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err = runCacheCommand(t, cache, "purge", "--older-than", "30d", "--keep-manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "Purged 2 samples") {
+		t.Errorf("Wrong purge output: %s", output)
+	}
+	output, err = runCacheCommand(t, cache, "delete", "--note", "recheck", "--author", "admin", "aaa", "ddd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "ddd not found") || !strings.Contains(output, "Deleted 1 samples") {
+		t.Errorf("Wrong delete output: %s", output)
+	}
+	output, err = runCacheCommand(t, cache, "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "Samples: 0\n") {
+		t.Errorf("Wrong stats output: %s", output)
+	}
+
+	exportFile := filepath.Join(t.TempDir(), "export.jsonl")
+	if err := os.WriteFile(exportFile, []byte(exported), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = runCacheCommand(t, cache, "import", "--input", exportFile, "--note", "restore", "--author", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "Imported 3 samples") {
+		t.Errorf("Wrong import output: %s", output)
+	}
+	output, err = runCacheCommand(t, cache, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "aaa ") {
+		t.Errorf("Wrong list output: %s", output)
+	}
+
+	broken := `{"sha1":"DDD","status":1,"risk_level":0}` + "\n" + `{"sha1":"eee"`
+	if err := os.WriteFile(exportFile, []byte(broken), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCacheCommand(t, cache, "import", "--input", exportFile, "--author", "admin"); err == nil {
+		t.Errorf("No error for broken import")
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(entry.SHA1)
+		actions = append(actions, fmt.Sprintf("%s %s %s %v", entry.Action, entry.Author, entry.Note, entry.SHA1))
+	}
+	expected := []string{
+		"set-verdict noRisk admin false positive [aaa]",
+		fmt.Sprintf("purge --older-than 30d %s  [bbb ccc]", currentUser()),
+		"delete admin recheck [aaa]",
+		"import admin restore [aaa bbb ccc]",
+		"import admin  [ddd]",
+	}
+	if strings.Join(actions, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected audit log %v, but got %v", expected, actions)
+	}
+}
+
+func TestParseRiskLevel(t *testing.T) {
+	testCases := map[string]ddan.Rating{
+		"highRisk":    ddan.RatingHighRisk,
+		"noRisk":      ddan.RatingNoRiskFound,
+		"unscannable": ddan.RatingUnsupported,
+		"2":           ddan.RatingMediumRisk,
+	}
+	for value, expected := range testCases {
+		actual, err := ParseRiskLevel(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("%s: expected %v, but got %v", value, expected, actual)
+		}
+	}
+	if _, err := ParseRiskLevel("wrong"); !errors.Is(err, ErrUnknownRiskLevel) {
+		t.Errorf("Expected %v, but got %v", ErrUnknownRiskLevel, err)
+	}
+}
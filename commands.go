@@ -0,0 +1,416 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+commands.go - command line interface
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var ErrUsage = errors.New("wrong usage")
+
+// version - CIA version. Set on build using -ldflags "-X main.version=..."
+var version = "dev"
+
+// Command - CIA subcommand
+type Command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands []Command
+
+func init() {
+	commands = []Command{
+		{"scan", "check all files in folder (default command)", runScan},
+		{"check-config", "check configuration without running the scan", runCheckConfig},
+		{"cache", "cache database operations", runCache},
+		{"filter", "filter rules operations", runFilterCommand},
+		{"version", "print CIA version", runVersion},
+		{"help", "print this help", runHelp},
+	}
+}
+
+// RunCommand - run subcommand given by first argument. Without subcommand scan is run
+func RunCommand(args []string) error {
+	name := "scan"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	for _, command := range commands {
+		if command.Name != name {
+			continue
+		}
+		// Usage is already printed by flags parser
+		if err := command.Run(args); !errors.Is(err, pflag.ErrHelp) {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown command %s. Run \"cia help\" for the list of commands", ErrUsage, name)
+}
+
+func runHelp(args []string) error {
+	fmt.Println("Check It All - check files using Trend Micro Deep Discovery Analyzer")
+	fmt.Println()
+	fmt.Println("Usage: cia [command] [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, command := range commands {
+		fmt.Printf("  %-14s%s\n", command.Name, command.Description)
+	}
+	fmt.Println()
+	fmt.Println("Run \"cia command --help\" for command options")
+	return nil
+}
+
+func runVersion(args []string) error {
+	fmt.Printf("cia %s\n", version)
+	return nil
+}
+
+// newFlagSet - create flags for command with --config option
+func newFlagSet(name string) (*pflag.FlagSet, *string) {
+	flags := pflag.NewFlagSet("cia "+name, pflag.ContinueOnError)
+	configFile := flags.String("config", "", "configuration file (default - cia.yaml in current folder)")
+	return flags, configFile
+}
+
+// parseFlags - parse command line, read configuration and bind flags to configuration keys
+func parseFlags(flags *pflag.FlagSet, configFile *string, args []string, keys map[string]string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := setupConfig(*configFile); err != nil {
+		return err
+	}
+	for flagName, key := range keys {
+		if err := viper.BindPFlag(key, flags.Lookup(flagName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addScanFlags - add flags that override configuration options for scan
+func addScanFlags(flags *pflag.FlagSet) map[string]string {
+	flags.StringSlice("folder", nil, "folders to check")
+	flags.String("files", "", "file with list of files to check. \"-\" for stdin")
+	flags.Bool("extract", false, "check members of archives instead of archives themselves")
+	flags.StringSlice("image", nil, "docker save or OCI image tarballs or OCI layout folders to check")
+	flags.String("s3-endpoint", "", "S3 compatible object storage URL")
+	flags.String("s3-bucket", "", "check objects of S3 bucket")
+	flags.String("s3-prefix", "", "check only S3 objects with given key prefix")
+	flags.String("git", "", "check files tracked by git repository in given folder")
+	flags.String("git-base", "", "check only files changed since given git ref")
+	flags.String("git-ref", "", "git ref to check (default HEAD)")
+	flags.String("filter", "", "filter rules file")
+	flags.StringSlice("skip", nil, "folder prefixes to skip")
+	flags.String("mime", "builtin", "MIME detector: builtin, file or libmagic")
+	flags.String("analyzer-url", "", "Analyzer URL")
+	flags.Bool("hash-only", false, "check files by SHA1 only and never upload them to Analyzer")
+	flags.Duration("scan-timeout", 0, "maximum time for the whole scan")
+	flags.String("report-json", "", "JSON report file")
+	flags.String("report-sarif", "", "SARIF report file")
+	flags.String("report-junit", "", "JUnit XML report file")
+	return map[string]string{
+		"folder":       "folder",
+		"files":        "files",
+		"extract":      "extract.enable",
+		"image":        "image",
+		"s3-endpoint":  "s3.endpoint",
+		"s3-bucket":    "s3.bucket",
+		"s3-prefix":    "s3.prefix",
+		"git":          "git.repo",
+		"git-base":     "git.base",
+		"git-ref":      "git.ref",
+		"filter":       "filter",
+		"skip":         "skip",
+		"mime":         "mime",
+		"analyzer-url": "analyzer.url",
+		"hash-only":    "analyzer.hashOnly",
+		"scan-timeout": "analyzer.scanTimeout",
+		"report-json":  "report.json",
+		"report-sarif": "report.sarif",
+		"report-junit": "report.junit",
+	}
+}
+
+func runScan(args []string) error {
+	flags, configFile := newFlagSet("scan")
+	keys := addScanFlags(flags)
+	if err := parseFlags(flags, configFile, args, keys); err != nil {
+		return err
+	}
+	log.Print("Started")
+	app, err := setupApplication()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if scanTimeout := viper.GetDuration("analyzer.scanTimeout"); scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scanTimeout)
+		defer cancel()
+	}
+
+	sources, err := setupSources()
+	if err != nil {
+		return err
+	}
+	err = app.Run(ctx, sources...)
+	if closer, ok := app.analyzer.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			log.Printf("Close cache: %v", closeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Print("Done")
+	return nil
+}
+
+func setupApplication() (*Application, error) {
+	analyzer, err := setupAnalyzer()
+	if err != nil {
+		return nil, err
+	}
+	if viper.GetDuration("analyzer.pullInterval") <= 0 {
+		return nil, fmt.Errorf("%w: analyzer.pullInterval should be positive", ErrUsage)
+	}
+	app := NewApplication(analyzer)
+	app.SetPrescanJobs(viper.GetInt("analyzer.prescanJobs"))
+	app.SetSubmitJobs(viper.GetInt("analyzer.submitJobs"))
+	app.SetPause(viper.GetDuration("analyzer.pullInterval"))
+	app.SetCheckBatch(viper.GetInt("analyzer.checkBatch"), viper.GetDuration("analyzer.checkWindow"))
+	app.SetPollBatch(viper.GetInt("analyzer.pollBatch"))
+	app.SetWaitTimeout(viper.GetDuration("analyzer.waitTimeout"))
+	app.SetMaxFileSize(viper.GetInt("analyzer.maxFileSize"))
+	app.SetFileRetries(viper.GetInt("analyzer.fileRetries"))
+	app.SetHashOnly(viper.GetBool("analyzer.hashOnly"))
+
+	err = SetMimeDetector(viper.GetString("mime"))
+	if err != nil {
+		return nil, err
+	}
+
+	filterPath := viper.GetString("filter")
+	if filterPath != "" {
+		filter, err := LoadFilter(filterPath)
+		if err != nil {
+			return nil, err
+		}
+		app.SetFilter(filter)
+	}
+
+	for _, each := range VerdictList {
+		app.SetAction(each, viper.GetBool("allow."+each))
+	}
+
+	skipFolders := viper.GetStringSlice("skip")
+	if skipFolders != nil {
+		app.SetSkipFolders(skipFolders)
+	}
+
+	if viper.GetBool("extract.enable") {
+		extractor, err := setupExtractor()
+		if err != nil {
+			return nil, err
+		}
+		app.SetExtractor(extractor)
+	}
+
+	report := setupReport()
+	if report != nil {
+		app.SetReport(report)
+	}
+	return app, nil
+}
+
+// setupSources - files to check: folders, files list, images, git repository and S3 bucket
+func setupSources() ([]Source, error) {
+	var sources []Source
+	for _, folder := range configList("folder") {
+		sources = append(sources, FolderSource(folder))
+	}
+	if files := viper.GetString("files"); files != "" {
+		sources = append(sources, NewListSource(files))
+	}
+	for _, image := range configList("image") {
+		sources = append(sources, NewImageSource(image))
+	}
+	if repo := gitRepo(); repo != "" {
+		sources = append(sources, NewGitSource(repo).
+			SetBase(viper.GetString("git.base")).
+			SetRef(viper.GetString("git.ref")))
+	}
+	if bucket := viper.GetString("s3.bucket"); bucket != "" {
+		client, err := setupS3Client()
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, NewS3Source(client, bucket, viper.GetString("s3.prefix")))
+	}
+	return sources, nil
+}
+
+func setupS3Client() (*S3Client, error) {
+	client, err := NewS3Client(viper.GetString("s3.endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	return client.
+		SetRegion(viper.GetString("s3.region")).
+		SetCredentials(viper.GetString("s3.accessKey"), viper.GetString("s3.secretKey")).
+		SetPathStyle(viper.GetBool("s3.pathStyle")).
+		SetIgnoreTLSError(viper.GetBool("s3.ignoreTLSError")), nil
+}
+
+// gitRepo - return git repository folder if git source is configured
+func gitRepo() string {
+	repo := viper.GetString("git.repo")
+	if repo == "" && (viper.GetString("git.base") != "" || viper.GetString("git.ref") != "") {
+		repo = "."
+	}
+	return repo
+}
+
+// configList - return option that can be either single value or list
+func configList(key string) []string {
+	if value, ok := viper.Get(key).(string); ok {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+	return viper.GetStringSlice(key)
+}
+
+func setupExtractor() (*Extractor, error) {
+	maxSize, err := ParseSize(viper.GetString("extract.maxSize"))
+	if err != nil {
+		return nil, fmt.Errorf("extract.maxSize: %w", err)
+	}
+	return NewExtractor().
+		SetMaxDepth(viper.GetInt("extract.maxDepth")).
+		SetMaxSize(maxSize).
+		SetMaxFiles(viper.GetInt("extract.maxFiles")).
+		SetMaxRatio(viper.GetFloat64("extract.maxRatio")), nil
+}
+
+func runCheckConfig(args []string) error {
+	flags, configFile := newFlagSet("check-config")
+	keys := addScanFlags(flags)
+	if err := parseFlags(flags, configFile, args, keys); err != nil {
+		return err
+	}
+	if used := viper.ConfigFileUsed(); used != "" {
+		fmt.Printf("Configuration file: %s\n", used)
+	}
+	problems := checkConfig()
+	settings := viper.AllSettings()
+	keyList := flattenSettings("", settings)
+	sort.Strings(keyList)
+	for _, key := range keyList {
+		value := fmt.Sprint(viper.Get(key))
+		if isSecret(key) && value != "" {
+			value = "***"
+		}
+		fmt.Printf("%s: %s\n", key, value)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Printf("ERROR: %v\n", problem)
+		}
+		return fmt.Errorf("found %d configuration errors", len(problems))
+	}
+	fmt.Println("Configuration is Ok")
+	return nil
+}
+
+// checkConfig - return list of configuration problems
+func checkConfig() (problems []error) {
+	if len(configList("folder")) == 0 && viper.GetString("files") == "" &&
+		gitRepo() == "" && len(configList("image")) == 0 && viper.GetString("s3.bucket") == "" {
+		problems = append(problems, errors.New("none of folder, files, git, image or s3 is set"))
+	}
+	if viper.GetString("s3.bucket") != "" {
+		if _, err := setupS3Client(); err != nil {
+			problems = append(problems, fmt.Errorf("s3.endpoint: %w", err))
+		}
+	}
+	analyzerURL := viper.GetString("analyzer.url")
+	if analyzerURL == "" {
+		problems = append(problems, errors.New("analyzer.url is not set"))
+	} else if _, err := url.Parse(analyzerURL); err != nil {
+		problems = append(problems, fmt.Errorf("analyzer.url: %w", err))
+	}
+	if viper.GetDuration("analyzer.pullInterval") <= 0 {
+		problems = append(problems, errors.New("analyzer.pullInterval should be positive"))
+	}
+	if viper.GetString("analyzer.apiKey") == "" {
+		problems = append(problems, errors.New("analyzer.apiKey is not set"))
+	}
+	if err := SetMimeDetector(viper.GetString("mime")); err != nil {
+		problems = append(problems, fmt.Errorf("mime: %w", err))
+	}
+	if filterPath := viper.GetString("filter"); filterPath != "" {
+		if _, err := LoadFilter(filterPath); err != nil {
+			problems = append(problems, fmt.Errorf("filter: %w", err))
+		}
+	}
+	if viper.GetBool("extract.enable") {
+		if _, err := setupExtractor(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	if _, err := setupVerdictExpiry(); err != nil {
+		problems = append(problems, err)
+	}
+	switch viper.GetString("cache.type") {
+	case "", "postgres", "postgresql", "sqlite", "sqlite3", "memory", "file":
+	default:
+		problems = append(problems, fmt.Errorf("cache.type %s is not supported", viper.GetString("cache.type")))
+	}
+	return
+}
+
+func flattenSettings(prefix string, settings map[string]interface{}) []string {
+	var keys []string
+	for key, value := range settings {
+		if nested, ok := value.(map[string]interface{}); ok {
+			keys = append(keys, flattenSettings(prefix+key+".", nested)...)
+			continue
+		}
+		keys = append(keys, prefix+key)
+	}
+	return keys
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasSuffix(key, "password") || strings.HasSuffix(key, "apikey") || strings.HasSuffix(key, "secretkey")
+}
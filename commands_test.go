@@ -0,0 +1,107 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+commands_test.go - tests for command line interface
+
+*/
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRunCommandUnknown(t *testing.T) {
+	err := RunCommand([]string{"unknown"})
+	if !errors.Is(err, ErrUsage) {
+		t.Errorf("Expected %v, but got %v", ErrUsage, err)
+	}
+}
+
+func TestRunCommandHelp(t *testing.T) {
+	defer viper.Reset()
+	for _, args := range [][]string{{"--help"}, {"scan", "-h"}, {"cache", "--help"}, {"cache", "list", "--help"}} {
+		if err := RunCommand(args); err != nil {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+}
+
+func TestSetupConfigMissingFile(t *testing.T) {
+	err := setupConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Errorf("No error for missing configuration file")
+	}
+}
+
+func TestFlattenSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"folder": "src",
+		"analyzer": map[string]interface{}{
+			"url": "https://analyzer",
+			"retry": map[string]interface{}{
+				"attempts": 3,
+			},
+		},
+	}
+	actual := flattenSettings("", settings)
+	sort.Strings(actual)
+	expected := []string{"analyzer.retry.attempts", "analyzer.url", "folder"}
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, actual)
+		}
+	}
+}
+
+func TestIsSecret(t *testing.T) {
+	testCases := map[string]bool{
+		"analyzer.apiKey": true,
+		"cache.password":  true,
+		"cache.user":      false,
+		"folder":          false,
+	}
+	for key, expected := range testCases {
+		if actual := isSecret(key); actual != expected {
+			t.Errorf("%s: expected %v, but got %v", key, expected, actual)
+		}
+	}
+}
+
+func TestCheckConfigExtract(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("extract.maxSize", "huge")
+	for _, enable := range []bool{false, true} {
+		viper.Set("extract.enable", enable)
+		found := false
+		for _, problem := range checkConfig() {
+			if strings.Contains(problem.Error(), "extract.maxSize") {
+				found = true
+			}
+		}
+		if found != enable {
+			t.Errorf("extract.enable %v: extract.maxSize problem reported %v", enable, found)
+		}
+	}
+}
+
+func TestCheckConfigPullInterval(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("analyzer.pullInterval", "0s")
+	for _, problem := range checkConfig() {
+		if strings.Contains(problem.Error(), "analyzer.pullInterval") {
+			return
+		}
+	}
+	t.Errorf("Zero analyzer.pullInterval is not reported")
+}
@@ -0,0 +1,127 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+explain.go - show how filter rules apply to files without contacting Analyzer
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+)
+
+// Explainer - applies filter to files and collects statistics for each rule
+type Explainer struct {
+	filter    *Filter
+	output    *tabwriter.Writer
+	matches   []int
+	byDefault int
+	submit    int
+	skip      int
+	errors    int
+}
+
+// NewExplainer - create explainer writing results to w
+func NewExplainer(filter *Filter, w io.Writer) *Explainer {
+	return &Explainer{
+		filter:  filter,
+		output:  tabwriter.NewWriter(w, 0, 4, 2, ' ', 0),
+		matches: make([]int, len(filter.Rules)),
+	}
+}
+
+// ExplainPath - explain file or all files in folder recursively
+func (e *Explainer) ExplainPath(path string) error {
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		e.ExplainFile(NewFileWithInfo(path, info))
+		return nil
+	})
+}
+
+// ExplainFile - print MIME type, matching rule and decision for file
+func (e *Explainer) ExplainFile(file *File) {
+	mime, err := file.Mime()
+	if err != nil {
+		mime = err.Error()
+	}
+	index, submit, err := e.filter.Explain(file)
+	ruleText := "default"
+	if index >= 0 {
+		ruleText = fmt.Sprintf("rule %d: %v", index+1, &e.filter.Rules[index])
+	}
+	decision := FilterSkip
+	switch {
+	case err != nil:
+		decision = "error: " + err.Error()
+		e.errors++
+	case submit:
+		decision = FilterSubmit
+		e.submit++
+	default:
+		e.skip++
+	}
+	if err == nil {
+		if index >= 0 {
+			e.matches[index]++
+		} else {
+			e.byDefault++
+		}
+	}
+	fmt.Fprintf(e.output, "%s\t%s\t%s\t%s\n", file.Path, mime, ruleText, decision)
+}
+
+// Summary - print number of files caught by each rule
+func (e *Explainer) Summary() error {
+	fmt.Fprintf(e.output, "\nRule\tFiles\n")
+	for i := range e.filter.Rules {
+		fmt.Fprintf(e.output, "%d: %v\t%d\n", i+1, &e.filter.Rules[i], e.matches[i])
+	}
+	fmt.Fprintf(e.output, "default\t%d\n", e.byDefault)
+	fmt.Fprintf(e.output, "\nSubmit: %d, skip: %d, errors: %d\n", e.submit, e.skip, e.errors)
+	return e.output.Flush()
+}
+
+// runFilterCommand - run filter subcommand
+func runFilterCommand(args []string) error {
+	if len(args) == 0 || args[0] != "explain" {
+		return fmt.Errorf("%w: cia filter explain [options] path...", ErrUsage)
+	}
+	flags, configFile := newFlagSet("filter explain")
+	flags.String("filter", "filter.yaml", "filter rules file")
+	flags.String("mime", "builtin", "MIME detector: builtin, file or libmagic")
+	keys := map[string]string{"filter": "filter", "mime": "mime"}
+	if err := parseFlags(flags, configFile, args[1:], keys); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no paths given", ErrUsage)
+	}
+	if err := SetMimeDetector(viper.GetString("mime")); err != nil {
+		return err
+	}
+	filter, err := LoadFilter(viper.GetString("filter"))
+	if err != nil {
+		return err
+	}
+	explainer := NewExplainer(filter, os.Stdout)
+	for _, path := range flags.Args() {
+		if err := explainer.ExplainPath(path); err != nil {
+			return err
+		}
+	}
+	return explainer.Summary()
+}
@@ -0,0 +1,53 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+explain_test.go - tests for Explainer
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainer(t *testing.T) {
+	filterFilePath := filepath.Join(t.TempDir(), "filter.yaml")
+	err := ioutil.WriteFile(filterFilePath, []byte(filterYaml), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := LoadFilter(filterFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	explainer := NewExplainer(filter, &buf)
+	err = explainer.ExplainPath(filepath.Join("testing_filter", "win32.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = explainer.ExplainPath(filepath.Join("testing_filter", "info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := explainer.Summary(); err != nil {
+		t.Fatal(err)
+	}
+	if explainer.matches[1] != 1 || explainer.byDefault != 1 {
+		t.Errorf("Expected rule 2 and default to catch 1 file each, but got %v and %d",
+			explainer.matches, explainer.byDefault)
+	}
+	output := buf.String()
+	if !strings.Contains(output, "rule 2: mime application/x*exe*") {
+		t.Errorf("Rule is not mentioned in output:\n%s", output)
+	}
+	if !strings.Contains(output, "Submit: 1, skip: 1, errors: 0") {
+		t.Errorf("Wrong summary:\n%s", output)
+	}
+}
@@ -0,0 +1,348 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+extract.go - extraction of archives members to check them separately
+
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrExtractLimit = errors.New("archive extraction limit exceeded")
+
+// ratioThreshold - compression ratio is not checked for archives with smaller extracted size
+const ratioThreshold = 1_000_000
+
+// Archive formats
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveGzip  = "gzip"
+	ArchiveBzip2 = "bzip2"
+)
+
+// archiveMime - archive formats by MIME type
+var archiveMime = map[string]string{
+	"application/zip":     ArchiveZip,
+	"application/x-tar":   ArchiveTar,
+	"application/gzip":    ArchiveGzip,
+	"application/x-gzip":  ArchiveGzip,
+	"application/x-bzip2": ArchiveBzip2,
+}
+
+// Extractor - extracts archives members to temporary folder
+type Extractor struct {
+	maxDepth int
+	maxSize  int64
+	maxFiles int
+	maxRatio float64
+	mx       sync.Mutex
+	tmpDir   string
+	count    int64
+}
+
+// extractLimits - size and number of files that still can be extracted from
+// the top level archive
+type extractLimits struct {
+	mx    sync.Mutex
+	size  int64
+	files int
+}
+
+// NewExtractor - create extractor with default limits
+func NewExtractor() *Extractor {
+	return &Extractor{
+		maxDepth: 3,
+		maxSize:  1_000_000_000,
+		maxFiles: 10_000,
+		maxRatio: 100,
+	}
+}
+
+// SetMaxDepth - set maximum nesting level of archives to extract
+func (e *Extractor) SetMaxDepth(maxDepth int) *Extractor {
+	e.maxDepth = maxDepth
+	return e
+}
+
+// SetMaxSize - set maximum total size of files extracted from the single archive
+// including nested ones
+func (e *Extractor) SetMaxSize(maxSize int64) *Extractor {
+	e.maxSize = maxSize
+	return e
+}
+
+// SetMaxFiles - set maximum number of files extracted from the single archive
+// including nested ones
+func (e *Extractor) SetMaxFiles(maxFiles int) *Extractor {
+	e.maxFiles = maxFiles
+	return e
+}
+
+// SetMaxRatio - set maximum ratio of extracted size to archive size
+func (e *Extractor) SetMaxRatio(maxRatio float64) *Extractor {
+	e.maxRatio = maxRatio
+	return e
+}
+
+// Format - return archive format of the file or empty string if file is not
+// supported archive
+func (e *Extractor) Format(file *File) (string, error) {
+	mime, err := file.Mime()
+	if err != nil {
+		return "", err
+	}
+	return archiveMime[mime], nil
+}
+
+// newLimits - return limits for top level archive
+func (e *Extractor) newLimits() *extractLimits {
+	return &extractLimits{size: e.maxSize, files: e.maxFiles}
+}
+
+// Close - remove all extracted files
+func (e *Extractor) Close() error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	if e.tmpDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(e.tmpDir)
+	e.tmpDir = ""
+	return err
+}
+
+// dir - create new folder for extracted files of single archive
+func (e *Extractor) dir() (string, error) {
+	e.mx.Lock()
+	if e.tmpDir == "" {
+		tmpDir, err := os.MkdirTemp("", "cia-extract-")
+		if err != nil {
+			e.mx.Unlock()
+			return "", err
+		}
+		e.tmpDir = tmpDir
+	}
+	tmpDir := e.tmpDir
+	e.mx.Unlock()
+	dir := filepath.Join(tmpDir, strconv.FormatInt(atomic.AddInt64(&e.count, 1), 10))
+	return dir, os.Mkdir(dir, 0o700)
+}
+
+// Extract - extract members of archive and call member function for each of
+// them. Members paths are archive path!member path
+func (e *Extractor) Extract(file *File, limits *extractLimits, member func(*File) error) error {
+	format, err := e.Format(file)
+	if err != nil {
+		return err
+	}
+	dirPath, err := e.dir()
+	if err != nil {
+		return err
+	}
+	dir := &extractDir{path: dirPath, count: 1}
+	defer dir.release()
+	x := &archiveExtraction{
+		extractor: e,
+		file:      file,
+		dir:       dir,
+		limits:    limits,
+		member:    member,
+	}
+	x.maxSize = int64(float64(file.Info.Size()) * e.maxRatio)
+	if x.maxSize < ratioThreshold {
+		x.maxSize = ratioThreshold
+	}
+	localPath, err := file.Stage()
+	if err != nil {
+		return err
+	}
+	if format == ArchiveZip {
+		return x.extractZip(localPath)
+	}
+	input, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	var reader io.Reader = input
+	name := path.Base(filepath.ToSlash(file.Path))
+	switch format {
+	case ArchiveGzip:
+		gzipReader, err := gzip.NewReader(input)
+		if err != nil {
+			return err
+		}
+		reader = gzipReader
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".tgz")
+	case ArchiveBzip2:
+		reader = bzip2.NewReader(input)
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".bz2"), ".tbz2")
+	}
+	buffered := bufio.NewReader(reader)
+	if format == ArchiveTar || isTar(buffered) {
+		return x.extractTar(buffered)
+	}
+	return x.extract(buffered, name, file.Info.ModTime())
+}
+
+// isTar - check ustar magic of the first tar header
+func isTar(input *bufio.Reader) bool {
+	header, _ := input.Peek(512)
+	return len(header) == 512 && bytes.HasPrefix(header[257:], []byte("ustar"))
+}
+
+// extractDir - folder with extracted members of single archive. It is removed
+// as soon as extraction is over and all members are checked
+type extractDir struct {
+	path  string
+	count int64
+}
+
+func (d *extractDir) acquire() {
+	atomic.AddInt64(&d.count, 1)
+}
+
+func (d *extractDir) release() {
+	if atomic.AddInt64(&d.count, -1) == 0 {
+		_ = os.RemoveAll(d.path)
+	}
+}
+
+// archiveExtraction - extraction of single archive
+type archiveExtraction struct {
+	extractor *Extractor
+	file      *File
+	dir       *extractDir
+	limits    *extractLimits
+	member    func(*File) error
+	maxSize   int64
+	size      int64
+	index     int
+}
+
+func (x *archiveExtraction) extractZip(localPath string) error {
+	reader, err := zip.OpenReader(localPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, each := range reader.File {
+		if !each.Mode().IsRegular() {
+			continue
+		}
+		if each.UncompressedSize64 > ratioThreshold && each.CompressedSize64 > 0 &&
+			float64(each.UncompressedSize64)/float64(each.CompressedSize64) > x.extractor.maxRatio {
+			return fmt.Errorf("%w: %s compression ratio exceeds %v", ErrExtractLimit, each.Name, x.extractor.maxRatio)
+		}
+		input, err := each.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", each.Name, err)
+		}
+		err = x.extract(input, each.Name, each.Modified)
+		input.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtraction) extractTar(input io.Reader) error {
+	tarReader := tar.NewReader(input)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA { //nolint
+			continue
+		}
+		if err := x.extract(tarReader, header.Name, header.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+// extract - write single member to disk checking limits and pass it to member function
+func (x *archiveExtraction) extract(input io.Reader, name string, modTime time.Time) error {
+	name, ok := entryName(filepath.ToSlash(name))
+	if !ok {
+		return nil
+	}
+	allowed, err := x.limits.take(1, 0)
+	if err != nil {
+		return err
+	}
+	if maxSize := x.maxSize - x.size; maxSize < allowed {
+		allowed = maxSize
+	}
+	x.index++
+	local := filepath.Join(x.dir.path, fmt.Sprintf("%d_%s", x.index, path.Base(name)))
+	output, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	size, err := io.CopyN(output, input, allowed+1)
+	if closeErr := output.Close(); err == nil || errors.Is(err, io.EOF) {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	x.size += size
+	if size > allowed {
+		return fmt.Errorf("%w: %s: total size of extracted files exceeds limit", ErrExtractLimit, name)
+	}
+	if _, err := x.limits.take(0, size); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(local, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	info, err := os.Lstat(local)
+	if err != nil {
+		return err
+	}
+	member := NewVirtualFile(x.file.Path+"!"+name, local, info)
+	x.dir.acquire()
+	member.release = x.dir.release
+	return x.member(member)
+}
+
+// take - account extracted files and size. Returns remaining size
+func (l *extractLimits) take(files int, size int64) (int64, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.files < files {
+		return 0, fmt.Errorf("%w: too many files", ErrExtractLimit)
+	}
+	l.files -= files
+	l.size -= size
+	return l.size, nil
+}
@@ -0,0 +1,185 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+extract_test.go - tests for Extractor
+
+*/
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zipBytes(t *testing.T, items []tarItem) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, item := range items {
+		f, err := w.Create(item.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(item.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func prepairArchive(t *testing.T, items []tarItem) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "outer.zip")
+	if err := os.WriteFile(archivePath, zipBytes(t, items), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func extractRecords(t *testing.T, archivePath string, extractor *Extractor, setup ...func(*Application)) map[string]Record {
+	t.Helper()
+	report := NewReport()
+	app := NewApplication(newFakeClient()).
+		SetPause(1 * time.Millisecond).
+		SetReport(report).
+		SetExtractor(extractor)
+	app.SetPrescanJobs(1).SetSubmitJobs(1)
+	for _, each := range setup {
+		each(app)
+	}
+	_ = app.Run(context.Background(), NewListSourceFromReader("list", strings.NewReader(archivePath)))
+	records := make(map[string]Record)
+	for _, record := range report.Records() {
+		records[strings.TrimPrefix(record.Path, archivePath)] = record
+	}
+	return records
+}
+
+func TestExtractArchive(t *testing.T) {
+	nested := string(gzipBytes(t, tarBytes(t, []tarItem{{"b.txt", "b"}, {"../../evil.txt", "e"}})))
+	archivePath := prepairArchive(t, []tarItem{
+		{"inner/a.txt", "a"},
+		{"nested.tar.gz", nested},
+	})
+	testCases := []struct {
+		name     string
+		maxDepth int
+		expected []string
+	}{
+		{"nested", 3, []string{"", "!inner/a.txt", "!nested.tar.gz", "!nested.tar.gz!b.txt", "!nested.tar.gz!evil.txt"}},
+		{"depth", 1, []string{"", "!inner/a.txt", "!nested.tar.gz"}},
+		{"none", 0, []string{""}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			extractor := NewExtractor().SetMaxDepth(tc.maxDepth)
+			records := extractRecords(t, archivePath, extractor)
+			if len(records) != len(tc.expected) {
+				t.Errorf("Expected %v, but got %v", tc.expected, records)
+			}
+			for _, path := range tc.expected {
+				if _, ok := records[path]; !ok {
+					t.Errorf("Missing %s in %v", path, records)
+				}
+			}
+			if (records[""].Filter == FilterExtract) != (tc.maxDepth > 0) {
+				t.Errorf("Wrong archive record: %v", records[""])
+			}
+			if tc.maxDepth == 1 && records["!nested.tar.gz"].Filter == FilterExtract {
+				t.Errorf("Archive deeper than maximum depth is extracted")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveSkipped(t *testing.T) {
+	archivePath := prepairArchive(t, []tarItem{{"a.txt", "a"}})
+	filter := &Filter{Default: DefaultSubmit, Rules: []Rule{{Type: "extension", Value: "zip"}}}
+	if err := filter.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name     string
+		setup    func(*Application)
+		expected Record
+	}{
+		{"filter", func(app *Application) { app.SetFilter(filter) }, Record{Filter: FilterSkip, Pass: true}},
+		{"size", func(app *Application) { app.SetMaxFileSize(10) }, Record{Filter: FilterNone, Verdict: "bigFile"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := extractRecords(t, archivePath, NewExtractor(), tc.setup)
+			if len(records) != 1 {
+				t.Errorf("Archive is extracted: %v", records)
+			}
+			archive := records[""]
+			if archive.Filter != tc.expected.Filter || archive.Verdict != tc.expected.Verdict || archive.Pass != tc.expected.Pass {
+				t.Errorf("Expected %v, but got %v", tc.expected, archive)
+			}
+		})
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	bomb := strings.Repeat("0", 2_000_000)
+	testCases := []struct {
+		name      string
+		items     []tarItem
+		extractor *Extractor
+	}{
+		{"files", []tarItem{{"a.txt", "a"}, {"b.txt", "b"}}, NewExtractor().SetMaxFiles(1)},
+		{"size", []tarItem{{"a.txt", "aaaa"}, {"b.txt", "bbbb"}}, NewExtractor().SetMaxSize(6)},
+		{"ratio", []tarItem{{"bomb.txt", bomb}}, NewExtractor()},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records := extractRecords(t, prepairArchive(t, tc.items), tc.extractor)
+			archive := records[""]
+			if archive.Verdict != "error" || archive.Pass {
+				t.Errorf("Expected error verdict, but got %v", archive)
+			}
+		})
+	}
+}
+
+func TestExtractRemove(t *testing.T) {
+	archivePath := prepairArchive(t, []tarItem{{"a.txt", "a"}, {"b.txt", "b"}})
+	file, err := NewFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractor := NewExtractor()
+	defer extractor.Close()
+	var members []*File
+	err = extractor.Extract(file, extractor.newLimits(), func(member *File) error {
+		members = append(members, member)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 members, but got %v", members)
+	}
+	dir := filepath.Dir(members[0].LocalPath())
+	for _, member := range members {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("Folder is removed before %s is checked: %v", member.Path, err)
+		}
+		member.Release()
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Folder is not removed: %v", err)
+	}
+}
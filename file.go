@@ -13,15 +13,30 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"os/exec"
-	"strings"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
 )
 
 type File struct {
-	Path string
-	Info os.FileInfo
-	mime string
+	Path     string
+	fsys     fs.FS
+	name     string
+	local    string
+	Info     os.FileInfo
+	mime     string
+	sha1     string
+	Filter   string
+	Report   *ddan.BriefReport
+	CacheAge time.Duration
+	Verdict  string
+	Pass     bool
+	Err      error
+	release  func()
 }
 
 func (f *File) String() string {
@@ -44,36 +59,161 @@ func NewFile(path string) (*File, error) {
 // NewFileWithInfo — create new File struct with path and FileInfo.
 func NewFileWithInfo(path string, info os.FileInfo) *File {
 	return &File{
-		Path: path,
-		Info: info,
-		mime: "",
+		Path:   path,
+		Info:   info,
+		mime:   "",
+		Filter: FilterNone,
 	}
 }
 
-// Mime - return MIME type of file.
-func (f *File) Mime() (string, error) {
-	if f.mime == "" {
-		options := []string{"--mime-type", "--brief", f.Path}
-		cmd := exec.Command("file", options...)
-		output, err := cmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("%s %v: %w", "file", options, err)
+// NewFileInFolder — create new File struct with path relative to root folder.
+func NewFileInFolder(root, path string, info os.FileInfo) *File {
+	return NewFSFile(DirFS(root), filepath.ToSlash(path), path, info)
+}
+
+// NewFSFile — create new File struct for file with given name in file system.
+func NewFSFile(fsys fs.FS, name, path string, info os.FileInfo) *File {
+	file := NewFileWithInfo(path, info)
+	file.fsys = fsys
+	file.name = name
+	return file
+}
+
+// NewVirtualFile — create new File struct which contents are stored in local file
+// with other path, i.e. extracted from archive or image.
+func NewVirtualFile(path, local string, info os.FileInfo) *File {
+	file := NewFileWithInfo(path, info)
+	file.local = local
+	return file
+}
+
+// LocalPath - return path of file contents on local disk. Empty string for
+// files that are not stored on local disk.
+func (f *File) LocalPath() string {
+	switch {
+	case f.local != "":
+		return f.local
+	case f.fsys != nil:
+		if localFS, ok := f.fsys.(LocalFS); ok {
+			return localFS.LocalPath(f.name)
+		}
+		return ""
+	default:
+		return f.Path
+	}
+}
+
+// Open - open file contents for reading.
+func (f *File) Open() (io.ReadCloser, error) {
+	if err := f.fetch(); err != nil {
+		return nil, err
+	}
+	return os.Open(f.LocalPath())
+}
+
+// Stage - return path of file contents on local disk. Files that are not
+// stored on local disk are copied to temporary file that is kept till Release.
+func (f *File) Stage() (string, error) {
+	if err := f.fetch(); err != nil {
+		return "", err
+	}
+	return f.LocalPath(), nil
+}
+
+// fetch - copy contents of file that is not stored on local disk to temporary
+// file, so it is read only once. SHA1 is calculated while copying.
+func (f *File) fetch() error {
+	if f.LocalPath() != "" {
+		return nil
+	}
+	input, err := f.fsys.Open(f.name)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.CreateTemp("", "cia-stage-*"+path.Ext(f.name))
+	if err != nil {
+		return err
+	}
+	remove := func() { _ = os.Remove(output.Name()) }
+	hash := sha1.New() //nolint
+	_, err = io.Copy(io.MultiWriter(output, hash), input)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		remove()
+		return fmt.Errorf("stage %s: %w", f.Path, err)
+	}
+	f.local = output.Name()
+	f.sha1 = hex.EncodeToString(hash.Sum(nil))
+	release := f.release
+	f.release = func() {
+		remove()
+		if release != nil {
+			release()
 		}
-		f.mime = strings.TrimRight(string(output), "\n")
 	}
+	return nil
+}
+
+// Release - free resources used to store file contents. Called once file
+// check is finished.
+func (f *File) Release() {
+	if f.release == nil {
+		return
+	}
+	f.release()
+	f.release = nil
+}
+
+// CopyResult - set the same check result as for other file.
+func (f *File) CopyResult(other *File) {
+	f.Report = other.Report
+	f.CacheAge = other.CacheAge
+	f.Verdict = other.Verdict
+	f.Pass = other.Pass
+	f.Err = other.Err
+}
+
+// Mime - return MIME type of file. Files that are not stored on local disk
+// are fetched first.
+func (f *File) Mime() (string, error) {
+	if f.mime != "" {
+		return f.mime, nil
+	}
+	if err := f.fetch(); err != nil {
+		return "", fmt.Errorf("detect MIME type: %w", err)
+	}
+	mime, err := mimeDetector.Detect(f.LocalPath())
+	if err != nil {
+		return "", err
+	}
+	f.mime = mime
 	return f.mime, nil
 }
 
-// FileSHA1 - return SHA1 for file.
+// FileSHA1 - return SHA1 for file. Files that are not stored on local disk are
+// fetched and hashed at once.
 func (f *File) Sha1() (string, error) {
-	input, err := os.Open(f.Path)
+	if f.sha1 != "" {
+		return f.sha1, nil
+	}
+	if err := f.fetch(); err != nil {
+		return "", fmt.Errorf("calculating SHA1 for file %s: %w", f.Path, err)
+	}
+	if f.sha1 != "" {
+		return f.sha1, nil
+	}
+	input, err := os.Open(f.LocalPath())
 	if err != nil {
 		return "", fmt.Errorf("calculating SHA1 for file %s: %w", f.Path, err)
 	}
+	defer input.Close()
 	hash := sha1.New() //nolint
-	_, err = io.Copy(hash, input)
-	if err != nil {
+	if _, err := io.Copy(hash, input); err != nil {
 		return "", fmt.Errorf("calculating SHA1 for file %s: %w", f.Path, err)
 	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	f.sha1 = hex.EncodeToString(hash.Sum(nil))
+	return f.sha1, nil
 }
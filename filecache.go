@@ -0,0 +1,209 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+filecache.go - embedded key-value file cache of analysis results
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrCacheClosed = errors.New("cache is closed")
+
+// fileRecord - verdict or deletion of verdict in cache file
+type fileRecord struct {
+	Verdict
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// FileCache - append only log of verdicts in the single file. All verdicts are
+// kept in memory. On close file is compacted to keep only the last verdict for
+// each sample
+type FileCache struct {
+	mx       sync.RWMutex
+	path     string
+	file     *os.File
+	verdicts map[string]Verdict
+	records  int
+}
+
+var _ VerdictCache = &FileCache{}
+
+// OpenFileCache - open or create cache file
+func OpenFileCache(path string) (*FileCache, error) {
+	c := &FileCache{
+		path:     path,
+		verdicts: make(map[string]Verdict),
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+// load - read all records. Truncated last record, i.e. after crash, is ignored
+func (c *FileCache) load() error {
+	file, err := os.Open(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var record fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("%s: line %d: %v", c.path, line, err)
+			continue
+		}
+		c.records++
+		if record.Deleted {
+			delete(c.verdicts, record.SHA1)
+			continue
+		}
+		c.verdicts[record.SHA1] = record.Verdict
+	}
+	return scanner.Err()
+}
+
+// Get - return verdict for sample
+func (c *FileCache) Get(sha1 string) (Verdict, bool, error) {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	verdict, found := c.verdicts[sha1]
+	return verdict, found, nil
+}
+
+// Put - store verdict
+func (c *FileCache) Put(verdict Verdict) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.file == nil {
+		return ErrCacheClosed
+	}
+	if err := c.write(fileRecord{Verdict: verdict}); err != nil {
+		return err
+	}
+	c.verdicts[verdict.SHA1] = verdict
+	return nil
+}
+
+// Delete - remove verdict
+func (c *FileCache) Delete(sha1 string) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.file == nil {
+		return ErrCacheClosed
+	}
+	if _, found := c.verdicts[sha1]; !found {
+		return nil
+	}
+	if err := c.write(fileRecord{Verdict: Verdict{SHA1: sha1}, Deleted: true}); err != nil {
+		return err
+	}
+	delete(c.verdicts, sha1)
+	return nil
+}
+
+// Range - call function for each verdict
+func (c *FileCache) Range(f func(Verdict) error) error {
+	c.mx.RLock()
+	verdicts := make([]Verdict, 0, len(c.verdicts))
+	for _, verdict := range c.verdicts {
+		verdicts = append(verdicts, verdict)
+	}
+	c.mx.RUnlock()
+	for _, verdict := range verdicts {
+		if err := f(verdict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *FileCache) write(record fileRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := c.file.Write(data); err != nil {
+		return err
+	}
+	c.records++
+	return nil
+}
+
+// Close - compact and close cache file
+func (c *FileCache) Close() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	if err != nil {
+		return err
+	}
+	if c.records > len(c.verdicts) {
+		return c.compact()
+	}
+	return nil
+}
+
+// compact - rewrite file with only actual records
+func (c *FileCache) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, verdict := range c.verdicts {
+		if err = encoder.Encode(verdict); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("compact %s: %w", c.path, err)
+	}
+	c.records = len(c.verdicts)
+	return nil
+}
@@ -12,6 +12,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/danwakefield/fnmatch"
 	"github.com/go-yaml/yaml"
@@ -20,19 +26,51 @@ import (
 var (
 	ErrUnknownRuleType = errors.New("unknown rule type")
 	ErrNoMatch         = errors.New("no match")
+	ErrWrongValue      = errors.New("wrong value")
+	ErrWrongRule       = errors.New("rule should have exactly one of type, all, any or not")
+	ErrUnknownSet      = errors.New("unknown rule set")
+	ErrIncludeLoop     = errors.New("include loop")
+	ErrNestedInclude   = errors.New("include is allowed only in rules lists")
 )
 
+const (
+	DefaultSubmit = "submit"
+	DefaultSkip   = "skip"
+)
+
+// Filter - filtering rules. Rules from imported files are applied after own rules
 type Filter struct {
-	Rules []Rule `yaml:"rules"`
+	Import  []string          `yaml:"import"`
+	Default string            `yaml:"default"`
+	Sets    map[string][]Rule `yaml:"sets"`
+	Rules   []Rule            `yaml:"rules"`
 }
 
+// Rule - single condition or combination of nested conditions. For nested
+// conditions submit option is ignored
 type Rule struct {
-	Submit bool   `yaml:"submit"`
-	Type   string `yaml:"type"`
-	Value  string `yaml:"value"`
+	Submit  bool   `yaml:"submit"`
+	Type    string `yaml:"type"`
+	Value   string `yaml:"value"`
+	All     []Rule `yaml:"all"`
+	Any     []Rule `yaml:"any"`
+	Not     *Rule  `yaml:"not"`
+	Include string `yaml:"include"`
+	regex   *regexp.Regexp
+	min     int64
+	max     int64
 }
 
 func LoadFilter(filePath string) (*Filter, error) {
+	return loadFilter(filePath, nil)
+}
+
+func loadFilter(filePath string, importing []string) (*Filter, error) {
+	for _, each := range importing {
+		if each == filePath {
+			return nil, fmt.Errorf("%s: %w", strings.Join(append(importing, filePath), " -> "), ErrIncludeLoop)
+		}
+	}
 	yamlData, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", filePath, err)
@@ -42,39 +80,338 @@ func LoadFilter(filePath string) (*Filter, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", filePath, err)
 	}
+	var importedRules []Rule
+	for _, importPath := range filter.Import {
+		if !filepath.IsAbs(importPath) {
+			importPath = filepath.Join(filepath.Dir(filePath), importPath)
+		}
+		imported, err := loadFilter(importPath, append(importing, filePath))
+		if err != nil {
+			return nil, err
+		}
+		filter.merge(imported)
+		importedRules = append(importedRules, imported.Rules...)
+	}
+	filter.Rules, err = filter.expand(filter.Rules, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	err = filter.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	filter.Rules = append(filter.Rules, importedRules...)
+	filter.Import = nil
 	return &filter, nil
 }
 
+// merge - add rule sets and default action from imported filter if they are not defined
+func (f *Filter) merge(imported *Filter) {
+	if f.Default == "" {
+		f.Default = imported.Default
+	}
+	for name, rules := range imported.Sets {
+		if _, found := f.Sets[name]; found {
+			continue
+		}
+		if f.Sets == nil {
+			f.Sets = make(map[string][]Rule)
+		}
+		f.Sets[name] = rules
+	}
+}
+
+// expand - replace include rules with rules from corresponding rule sets
+func (f *Filter) expand(rules []Rule, including []string) ([]Rule, error) {
+	var result []Rule
+	for _, rule := range rules {
+		if rule.Include == "" {
+			result = append(result, rule)
+			continue
+		}
+		for _, each := range including {
+			if each == rule.Include {
+				return nil, fmt.Errorf("%s: %w", strings.Join(append(including, rule.Include), " -> "), ErrIncludeLoop)
+			}
+		}
+		set, found := f.Sets[rule.Include]
+		if !found {
+			return nil, fmt.Errorf("%s: %w", rule.Include, ErrUnknownSet)
+		}
+		expanded, err := f.expand(set, append(including, rule.Include))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded...)
+	}
+	return result, nil
+}
+
+// Compile - check and prepare all rules
+func (f *Filter) Compile() error {
+	switch f.Default {
+	case "", DefaultSubmit, DefaultSkip:
+	default:
+		return fmt.Errorf("default: %s: %w", f.Default, ErrWrongValue)
+	}
+	for i := range f.Rules {
+		err := f.Rules[i].Compile()
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Compile - check rule value and prepare it for matching
+func (r *Rule) Compile() (err error) {
+	if r.Include != "" {
+		return fmt.Errorf("%s: %w", r.Include, ErrNestedInclude)
+	}
+	kinds := 0
+	for _, set := range []bool{r.Type != "", r.All != nil, r.Any != nil, r.Not != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return ErrWrongRule
+	}
+	switch {
+	case r.All != nil:
+		return compileRules("all", r.All)
+	case r.Any != nil:
+		return compileRules("any", r.Any)
+	case r.Not != nil:
+		if err := r.Not.Compile(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+		return nil
+	}
+	switch r.Type {
+	case "path", "mime", "name", "extension":
+	case "regex":
+		r.regex, err = regexp.Compile(r.Value)
+	case "glob":
+		r.regex, err = CompileGlob(r.Value)
+	case "size":
+		r.min, r.max, err = ParseRange(r.Value, ParseSize)
+	case "mtime":
+		r.min, r.max, err = ParseRange(r.Value, ParseAge)
+	default:
+		return fmt.Errorf("%s: %w", r.Type, ErrUnknownRuleType)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", r.Type, r.Value, err)
+	}
+	return nil
+}
+
+func compileRules(name string, rules []Rule) error {
+	if len(rules) == 0 {
+		return fmt.Errorf("%s: %w: no conditions", name, ErrWrongValue)
+	}
+	for i := range rules {
+		if err := rules[i].Compile(); err != nil {
+			return fmt.Errorf("%s %d: %w", name, i+1, err)
+		}
+	}
+	return nil
+}
+
 func (r *Rule) ShouldSubmit(file *File) (bool, error) {
+	match, err := r.Match(file)
+	if err != nil {
+		return false, err
+	}
+	if match {
+		return r.Submit, nil
+	}
+	return false, ErrNoMatch
+}
+
+// Match - return whenever file matches rule
+func (r *Rule) Match(file *File) (bool, error) {
+	switch {
+	case r.All != nil:
+		for i := range r.All {
+			match, err := r.All[i].Match(file)
+			if err != nil || !match {
+				return false, err
+			}
+		}
+		return true, nil
+	case r.Any != nil:
+		for i := range r.Any {
+			match, err := r.Any[i].Match(file)
+			if err != nil || match {
+				return match, err
+			}
+		}
+		return false, nil
+	case r.Not != nil:
+		match, err := r.Not.Match(file)
+		return !match && err == nil, err
+	}
 	switch r.Type {
 	case "path":
-		if fnmatch.Match(r.Value, file.Path, 0) {
-			return r.Submit, nil
-		}
+		return fnmatch.Match(r.Value, file.Path, 0), nil
 	case "mime":
 		mime, err := file.Mime()
 		if err != nil {
 			return false, err
 		}
-		if fnmatch.Match(r.Value, mime, 0) {
-			return r.Submit, nil
-		}
+		return fnmatch.Match(r.Value, mime, 0), nil
+	case "name":
+		return fnmatch.Match(r.Value, filepath.Base(file.Path), 0), nil
+	case "extension":
+		return MatchExtension(r.Value, file.Path), nil
+	case "regex", "glob":
+		return r.regex.MatchString(filepath.ToSlash(file.Path)), nil
+	case "size":
+		return r.min <= file.Info.Size() && file.Info.Size() <= r.max, nil
+	case "mtime":
+		age := int64(time.Since(file.Info.ModTime()))
+		return r.min <= age && age <= r.max, nil
 	default:
 		return false, fmt.Errorf("%s: %w", r.Type, ErrUnknownRuleType)
 	}
-	return false, ErrNoMatch
 }
 
 func (f *Filter) CheckFile(file *File) (bool, error) {
-	for _, r := range f.Rules {
-		shouldSubmit, err := r.ShouldSubmit(file)
+	_, submit, err := f.Explain(file)
+	return submit, err
+}
+
+// Explain - return index of the rule that matches file (-1 if default action is applied)
+// and whenever file should be submitted
+func (f *Filter) Explain(file *File) (int, bool, error) {
+	for i := range f.Rules {
+		shouldSubmit, err := f.Rules[i].ShouldSubmit(file)
 		if err != nil {
 			if errors.Is(err, ErrNoMatch) {
 				continue
 			}
-			return false, err
+			return i, false, err
 		}
-		return shouldSubmit, nil
+		return i, shouldSubmit, nil
+	}
+	return -1, f.Default == DefaultSubmit, nil
+}
+
+func (r *Rule) String() string {
+	switch {
+	case r.All != nil:
+		return "all(" + joinRules(r.All) + ")"
+	case r.Any != nil:
+		return "any(" + joinRules(r.Any) + ")"
+	case r.Not != nil:
+		return "not(" + r.Not.String() + ")"
+	case r.Include != "":
+		return "include " + r.Include
+	}
+	return fmt.Sprintf("%s %s", r.Type, r.Value)
+}
+
+func joinRules(rules []Rule) string {
+	result := make([]string, len(rules))
+	for i := range rules {
+		result[i] = rules[i].String()
+	}
+	return strings.Join(result, ", ")
+}
+
+// MatchExtension - check path extension against comma separated list of extensions
+func MatchExtension(extensions string, path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, each := range strings.Split(extensions, ",") {
+		each = strings.TrimPrefix(strings.TrimSpace(each), ".")
+		if strings.EqualFold(each, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10},
+	{"MIB", 1 << 20},
+	{"GIB", 1 << 30},
+	{"TIB", 1 << 40},
+	{"KB", 1_000},
+	{"MB", 1_000_000},
+	{"GB", 1_000_000_000},
+	{"TB", 1_000_000_000_000},
+	{"K", 1_000},
+	{"M", 1_000_000},
+	{"G", 1_000_000_000},
+	{"T", 1_000_000_000_000},
+	{"B", 1},
+}
+
+// ParseSize - parse size with optional unit, e.g. 100, 10KB, 5MiB
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.multiplier
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%s: %w", value, ErrWrongValue)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// ParseAge - parse time duration with days and weeks support, e.g. 12h, 30d, 2w
+func ParseAge(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		multiplier = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		multiplier = 7 * 24 * time.Hour
+	}
+	if multiplier == 0 {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", value, ErrWrongValue)
+		}
+		return int64(duration), nil
+	}
+	count, err := strconv.ParseFloat(value[:len(value)-1], 64)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("%s: %w", value, ErrWrongValue)
+	}
+	return int64(count * float64(multiplier)), nil
+}
+
+// ParseRange - parse range in one of the forms: "<max", ">min", "min-max" or exact value
+func ParseRange(value string, parse func(string) (int64, error)) (min, max int64, err error) {
+	value = strings.TrimSpace(value)
+	min, max = 0, math.MaxInt64
+	switch {
+	case strings.HasPrefix(value, "<"):
+		max, err = parse(value[1:])
+	case strings.HasPrefix(value, ">"):
+		min, err = parse(value[1:])
+	case strings.Contains(value, "-"):
+		i := strings.Index(value, "-")
+		min, err = parse(value[:i])
+		if err == nil {
+			max, err = parse(value[i+1:])
+		}
+	default:
+		min, err = parse(value)
+		max = min
 	}
-	return false, nil
+	return
 }
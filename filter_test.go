@@ -9,11 +9,8 @@ filter_test.go - tests for Filter
 package main
 
 import (
-	"fmt"
 	"io/ioutil"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"testing"
 )
 
@@ -108,11 +105,244 @@ func TestFileInfo(t *testing.T) {
 }
 
 func mimeType(filePath string) (string, error) {
-	options := []string{"--mime-type", "--brief", filePath}
-	cmd := exec.Command("file", options...)
-	output, err := cmd.Output()
+	return mimeDetector.Detect(filePath)
+}
+
+func TestFilterRuleTypes(t *testing.T) {
+	testingFolder := "testing_filter"
+	testCases := []struct {
+		rule     Rule
+		fileName string
+		expected bool
+	}{
+		{Rule{Type: "size", Value: "<1KB"}, "info.txt", true},
+		{Rule{Type: "size", Value: ">1KB"}, "info.txt", false},
+		{Rule{Type: "size", Value: "10KB-20KB"}, "tiny", true},
+		{Rule{Type: "size", Value: "100"}, "info.txt", true},
+		{Rule{Type: "extension", Value: "exe, dll"}, "win32.exe", true},
+		{Rule{Type: "extension", Value: ".EXE"}, "win32.exe", true},
+		{Rule{Type: "extension", Value: "exe"}, "tiny.c", false},
+		{Rule{Type: "name", Value: "tiny*"}, "tiny.c", true},
+		{Rule{Type: "name", Value: "testing_filter*"}, "tiny.c", false},
+		{Rule{Type: "regex", Value: `\.(py|sh)$`}, "shell.sh", true},
+		{Rule{Type: "regex", Value: `\.(py|sh)$`}, "tiny.c", false},
+		{Rule{Type: "glob", Value: "**/*.c"}, "tiny.c", true},
+		{Rule{Type: "glob", Value: "testing_filter/**"}, "tiny.c", true},
+		{Rule{Type: "glob", Value: "**/testdata/**"}, "tiny.c", false},
+		{Rule{Type: "mtime", Value: "<1000w"}, "tiny.c", true},
+		{Rule{Type: "mtime", Value: ">1000w"}, "tiny.c", false},
+	}
+	for _, tc := range testCases {
+		rule := tc.rule
+		if err := rule.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := NewFile(filepath.Join(testingFolder, tc.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := rule.Match(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%s %s: expected %v for %s, but got %v", rule.Type, rule.Value, tc.expected, tc.fileName, actual)
+		}
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	rules := []Rule{
+		{Type: "unknown", Value: "*"},
+		{Type: "size", Value: "big"},
+		{Type: "mtime", Value: "<yesterday"},
+		{Type: "regex", Value: "("},
+	}
+	for _, rule := range rules {
+		if err := rule.Compile(); err == nil {
+			t.Errorf("%s %s: expected error", rule.Type, rule.Value)
+		}
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"**/testdata/**", "testdata/a.txt", true},
+		{"**/testdata/**", "src/pkg/testdata/a/b.txt", true},
+		{"**/testdata/**", "src/testdata.go", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/*.go", "src/pkg/main.go", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/pkg/main.go", true},
+		{"file?.[ch]", "file1.c", true},
+		{"file?.[!ch]", "file1.c", false},
+	}
+	for _, tc := range testCases {
+		re, err := CompileGlob(tc.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := re.MatchString(tc.path)
+		if actual != tc.expected {
+			t.Errorf("%s: expected %v for %s, but got %v", tc.pattern, tc.expected, tc.path, actual)
+		}
+	}
+}
+
+var compositeFilterYaml = `rules:
+  - submit: true
+    all:
+      - type: mime
+        value: '*shellscript'
+      - not:
+          type: name
+          value: 'test_*'
+      - any:
+          - type: glob
+            value: 'deploy/**'
+          - type: glob
+            value: 'testing_filter/**'
+  - submit: false
+    type: path
+    value: '*'
+`
+
+func TestFilterComposite(t *testing.T) {
+	filterFilePath := filepath.Join(t.TempDir(), "filter.yaml")
+	err := ioutil.WriteFile(filterFilePath, []byte(compositeFilterYaml), 0o600)
 	if err != nil {
-		return "", fmt.Errorf("%s %v: %w", "file", options, err)
+		t.Fatal(err)
+	}
+	filter, err := LoadFilter(filterFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fileName, expected := range map[string]bool{
+		"shell.sh":  true,
+		"python.py": false,
+		"win32.exe": false,
+	} {
+		file, err := NewFile(filepath.Join("testing_filter", fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		submit, err := filter.CheckFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if submit != expected {
+			t.Errorf("%s: expected %v, but got %v", fileName, expected, submit)
+		}
+	}
+}
+
+func TestFilterCompositeErrors(t *testing.T) {
+	rules := []Rule{
+		{},
+		{Type: "path", Value: "*", Not: &Rule{Type: "path", Value: "*"}},
+		{All: []Rule{}},
+		{Any: []Rule{{Type: "unknown"}}},
+		{Not: &Rule{}},
+	}
+	for i, rule := range rules {
+		if err := rule.Compile(); err == nil {
+			t.Errorf("Rule %d: expected error", i)
+		}
+	}
+}
+
+var centralFilterYaml = `default: submit
+sets:
+  executables:
+    - submit: true
+      type: mime
+      value: 'application/x*exe*'
+  scripts:
+    - submit: true
+      type: mime
+      value: '*shellscript'
+rules:
+  - submit: false
+    type: extension
+    value: txt
+`
+
+var projectFilterYaml = `import:
+  - central.yaml
+sets:
+  archives:
+    - submit: true
+      type: mime
+      value: 'application/*zip'
+    - include: scripts
+rules:
+  - include: executables
+  - include: archives
+  - submit: false
+    type: extension
+    value: py
+`
+
+func TestFilterImport(t *testing.T) {
+	folder := t.TempDir()
+	for fileName, content := range map[string]string{
+		"central.yaml": centralFilterYaml,
+		"filter.yaml":  projectFilterYaml,
+	} {
+		err := ioutil.WriteFile(filepath.Join(folder, fileName), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter, err := LoadFilter(filepath.Join(folder, "filter.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.Rules) != 5 {
+		t.Errorf("Expected 5 rules, but got %d", len(filter.Rules))
+	}
+	for fileName, expected := range map[string]bool{
+		"win32.exe":  true,
+		"shell.sh":   true,
+		"archive.gz": true,
+		"python.py":  false,
+		"info.txt":   false,
+		"tiny.c":     true,
+	} {
+		file, err := NewFile(filepath.Join("testing_filter", fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		submit, err := filter.CheckFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if submit != expected {
+			t.Errorf("%s: expected %v, but got %v", fileName, expected, submit)
+		}
+	}
+}
+
+func TestFilterIncludeErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown set": "rules:\n  - include: missing\n",
+		"loop":        "sets:\n  a:\n    - include: b\n  b:\n    - include: a\nrules:\n  - include: a\n",
+		"nested":      "sets:\n  a:\n    - submit: true\n      type: path\n      value: '*'\nrules:\n  - not:\n      include: a\n",
+		"default":     "default: maybe\n",
+		"import loop": "import:\n  - filter.yaml\n",
+	} {
+		filterFilePath := filepath.Join(t.TempDir(), "filter.yaml")
+		err := ioutil.WriteFile(filterFilePath, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadFilter(filterFilePath)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
 	}
-	return strings.TrimRight(string(output), "\n"), nil
 }
@@ -0,0 +1,55 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+fs.go - file systems to get files from
+
+*/
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LocalFS - file system that stores files on local disk. Such files are
+// uploaded to Analyzer directly without copying to temporary file
+type LocalFS interface {
+	fs.FS
+	LocalPath(name string) string
+}
+
+// DirFS - local folder as file system
+type DirFS string
+
+var _ LocalFS = DirFS("")
+
+// Open - open file with given slash separated name
+func (d DirFS) Open(name string) (fs.File, error) {
+	return os.DirFS(string(d)).Open(name)
+}
+
+// LocalPath - return path of the file on local disk
+func (d DirFS) LocalPath(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+// FSSource - recursively check all files of file system. Files paths are
+// prefixed with source name
+type FSSource struct {
+	fsys fs.FS
+	name string
+}
+
+// NewFSSource - create source for given file system
+func NewFSSource(fsys fs.FS, name string) *FSSource {
+	return &FSSource{fsys: fsys, name: name}
+}
+
+// Scan - walk file system
+func (s *FSSource) Scan(ctx context.Context, a *Application) error {
+	return a.WalkFS(ctx, s.fsys, s.name)
+}
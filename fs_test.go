@@ -0,0 +1,157 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+fs_test.go - tests for file system sources
+
+*/
+
+package main
+
+import (
+	"context"
+	sha1sum "crypto/sha1" //nolint
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// contentClient - fake client that remembers contents of uploaded files
+type contentClient struct {
+	*fakeClient
+	mx       sync.Mutex
+	contents map[string]string
+}
+
+func (c *contentClient) UploadSample(ctx context.Context, filePath, sha1 string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	c.mx.Lock()
+	c.contents[sha1] = string(data)
+	c.mx.Unlock()
+	return c.fakeClient.UploadSample(ctx, filePath, sha1)
+}
+
+func TestFSSource(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("a")},
+		"bin/run.sh":     {Data: []byte("#!/bin/sh\necho run\n")},
+		"skip/b.txt":     {Data: []byte("b")},
+		"deep/er/c.html": {Data: []byte("<html><body>c</body></html>")},
+	}
+	client := &contentClient{fakeClient: newFakeClient(), contents: make(map[string]string)}
+	report := NewReport()
+	app := NewApplication(client).
+		SetPause(1 * time.Millisecond).
+		SetReport(report).
+		SetSkipFolders([]string{filepath.Join("mem", "skip")})
+	app.SetPrescanJobs(2).SetSubmitJobs(2)
+	err := app.Run(context.Background(), NewFSSource(fsys, "mem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		filepath.Join("mem", "a.txt"):                "text/plain",
+		filepath.Join("mem", "bin", "run.sh"):        "text/x-shellscript",
+		filepath.Join("mem", "deep", "er", "c.html"): "text/html",
+	}
+	records := report.Records()
+	if len(records) != len(expected) {
+		t.Errorf("Expected %d records, but got %v", len(expected), records)
+	}
+	for _, record := range records {
+		mime, ok := expected[record.Path]
+		if !ok {
+			t.Errorf("Unexpected record: %v", record)
+			continue
+		}
+		if record.MIME != mime {
+			t.Errorf("%s: expected %s, but got %s", record.Path, mime, record.MIME)
+		}
+		name := filepath.ToSlash(record.Path[len("mem/"):])
+		if client.contents[record.SHA1] != string(fsys[name].Data) {
+			t.Errorf("%s: wrong uploaded content %q", record.Path, client.contents[record.SHA1])
+		}
+	}
+}
+
+func TestFileStage(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("content")}}
+	info, err := fsys.Stat("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := NewFSFile(fsys, "a.txt", "a.txt", info)
+	if file.LocalPath() != "" {
+		t.Errorf("Local path for in-memory file: %s", file.LocalPath())
+	}
+	localPath, err := file.Stage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "content" {
+		t.Errorf("Wrong staged content: %q", data)
+	}
+	file.Release()
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Errorf("Staged file is not removed: %v", err)
+	}
+	folder := t.TempDir()
+	local := NewFileInFolder(folder, "b.txt", nil)
+	if local.LocalPath() != filepath.Join(folder, "b.txt") {
+		t.Errorf("Wrong local path: %s", local.LocalPath())
+	}
+}
+
+// countingFS - file system that counts opens of each file
+type countingFS struct {
+	fstest.MapFS
+	opens map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opens[name]++
+	return c.MapFS.Open(name)
+}
+
+func TestFileReadOnce(t *testing.T) {
+	content := "#!/bin/sh\necho a\n"
+	fsys := &countingFS{MapFS: fstest.MapFS{"a.sh": {Data: []byte(content)}}, opens: make(map[string]int)}
+	info, err := fsys.Stat("a.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := NewFSFile(fsys, "a.sh", "a.sh", info)
+	mime, err := file.Mime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mime != "text/x-shellscript" {
+		t.Errorf("Wrong MIME type: %s", mime)
+	}
+	sha1, err := file.Sha1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1sum.Sum([]byte(content))
+	if sha1 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Wrong SHA1: %s", sha1)
+	}
+	if _, err := file.Stage(); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.opens["a.sh"] != 1 {
+		t.Errorf("Expected 1 read, but got %d", fsys.opens["a.sh"])
+	}
+}
@@ -0,0 +1,284 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+git.go - check files of local git repository
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrGit = errors.New("git")
+
+// GitSource - check files tracked by git at given ref or only files added or
+// modified since base ref. File paths are relative to repository root
+type GitSource struct {
+	repo string
+	base string
+	ref  string
+}
+
+// NewGitSource - create source for git repository containing given folder
+func NewGitSource(repo string) *GitSource {
+	return &GitSource{
+		repo: repo,
+		ref:  "HEAD",
+	}
+}
+
+// SetBase - check only files changed since merge base of base and ref
+func (s *GitSource) SetBase(base string) *GitSource {
+	s.base = base
+	return s
+}
+
+// SetRef - set ref to get files list for. Default is HEAD
+func (s *GitSource) SetRef(ref string) *GitSource {
+	if ref != "" {
+		s.ref = ref
+	}
+	return s
+}
+
+// Scan - pass files from git to application. File contents are read from git
+// objects of ref, so uncommitted changes of working tree are not checked
+func (s *GitSource) Scan(ctx context.Context, a *Application) error {
+	root, err := s.git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return err
+	}
+	root = strings.TrimSpace(root)
+	paths, err := s.Files(ctx)
+	if err != nil {
+		return err
+	}
+	if s.base != "" {
+		log.Printf("Process %d files changed in %s since %s: %s", len(paths), s.ref, s.base, root)
+	} else {
+		log.Printf("Process %d files of %s: %s", len(paths), s.ref, root)
+	}
+	return s.scanTree(ctx, a, paths)
+}
+
+// Files - return list of paths relative to repository root
+func (s *GitSource) Files(ctx context.Context) ([]string, error) {
+	var output string
+	var err error
+	if s.base != "" {
+		output, err = s.git(ctx, "diff", "--name-only", "-z", "--diff-filter=ACMRT", s.base+"..."+s.ref, "--")
+	} else {
+		output, err = s.git(ctx, "ls-tree", "-r", "-z", "--name-only", "--full-tree", s.ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(output, "\x00") {
+		if path != "" {
+			paths = append(paths, filepath.FromSlash(path))
+		}
+	}
+	return paths, nil
+}
+
+// scanTree - pass files with contents read from git objects of ref
+func (s *GitSource) scanTree(ctx context.Context, a *Application, paths []string) error {
+	fsys, err := NewGitFS(ctx, s.repo, s.ref)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		if a.ShouldSkipFolder(path) {
+			continue
+		}
+		name := filepath.ToSlash(path)
+		entry, found := fsys.entries[name]
+		if !found {
+			log.Printf("Ignore submodule or symbolic link: %s", path)
+			continue
+		}
+		a.AddFile(NewFSFile(fsys, name, path, gitFileInfo{entry, fsys.modTime}))
+	}
+	return nil
+}
+
+func (s *GitSource) git(ctx context.Context, args ...string) (string, error) {
+	args = append([]string{"-C", s.repo}, args...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if ctxErr := ContextError(ctx); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("%w %s: %v: %s", ErrGit, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// gitEntry - regular file of git tree
+type gitEntry struct {
+	name   string
+	mode   fs.FileMode
+	object string
+	size   int64
+}
+
+// GitFS - files of git tree at given ref. Contents are read from git objects.
+// Names are paths relative to repository root. Modification time of all files
+// is commit time of ref
+type GitFS struct {
+	ctx     context.Context
+	repo    string
+	modTime time.Time
+	entries map[string]gitEntry
+}
+
+// NewGitFS - list regular files of ref tree. Context is used for all git commands
+func NewGitFS(ctx context.Context, repo, ref string) (*GitFS, error) {
+	fsys := &GitFS{ctx: ctx, repo: repo, entries: make(map[string]gitEntry)}
+	source := NewGitSource(repo)
+	commitTime, err := source.git(ctx, "log", "-1", "--format=%ct", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(commitTime), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w log: wrong commit time: %q", ErrGit, commitTime)
+	}
+	fsys.modTime = time.Unix(seconds, 0)
+	output, err := source.git(ctx, "ls-tree", "-r", "-z", "-l", "--full-tree", ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(output, "\x00") {
+		if line == "" {
+			continue
+		}
+		entry, err := parseTreeEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		if entry.mode.IsRegular() {
+			fsys.entries[entry.name] = entry
+		}
+	}
+	return fsys, nil
+}
+
+// parseTreeEntry - parse "<mode> <type> <object> <size>\t<path>" line of git ls-tree -l
+func parseTreeEntry(line string) (gitEntry, error) {
+	fields := strings.SplitN(line, "\t", 2)
+	attributes := strings.Fields(fields[0])
+	if len(fields) != 2 || len(attributes) != 4 {
+		return gitEntry{}, fmt.Errorf("%w ls-tree: wrong line: %q", ErrGit, line)
+	}
+	entry := gitEntry{name: fields[1], object: attributes[2]}
+	switch attributes[0] {
+	case "100644":
+		entry.mode = 0o644
+	case "100755":
+		entry.mode = 0o755
+	default:
+		entry.mode = fs.ModeIrregular
+		return entry, nil
+	}
+	size, err := strconv.ParseInt(attributes[3], 10, 64)
+	if err != nil {
+		return gitEntry{}, fmt.Errorf("%w ls-tree: wrong size: %q", ErrGit, line)
+	}
+	entry.size = size
+	return entry, nil
+}
+
+// Open - start reading of the blob
+func (g *GitFS) Open(name string) (fs.File, error) {
+	entry, found := g.entries[name]
+	if !found || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	cmd := exec.CommandContext(g.ctx, "git", "-C", g.repo, "cat-file", "blob", entry.object)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &gitFile{entry: entry, modTime: g.modTime, cmd: cmd, stdout: stdout, stderr: &stderr}, nil
+}
+
+// gitFile - blob contents streamed from git cat-file
+type gitFile struct {
+	entry   gitEntry
+	modTime time.Time
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  *bytes.Buffer
+	done    bool
+}
+
+// Read - read blob contents. Failure of git command is reported instead of end of file
+func (f *gitFile) Read(p []byte) (int, error) {
+	if f.done {
+		return 0, io.EOF
+	}
+	n, err := f.stdout.Read(p)
+	if err == io.EOF && !f.done {
+		f.done = true
+		if waitErr := f.cmd.Wait(); waitErr != nil {
+			return n, fmt.Errorf("%w cat-file %s: %v: %s", ErrGit, f.entry.name, waitErr, strings.TrimSpace(f.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+func (f *gitFile) Stat() (fs.FileInfo, error) {
+	return gitFileInfo{f.entry, f.modTime}, nil
+}
+
+// Close - stop git command if blob was not read till the end
+func (f *gitFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	_ = f.cmd.Process.Kill()
+	_ = f.cmd.Wait()
+	return nil
+}
+
+// gitFileInfo - tree entry attributes as fs.FileInfo
+type gitFileInfo struct {
+	entry   gitEntry
+	modTime time.Time
+}
+
+func (i gitFileInfo) Name() string       { return path.Base(i.entry.name) }
+func (i gitFileInfo) Size() int64        { return i.entry.size }
+func (i gitFileInfo) Mode() fs.FileMode  { return i.entry.mode }
+func (i gitFileInfo) ModTime() time.Time { return i.modTime }
+func (i gitFileInfo) IsDir() bool        { return false }
+func (i gitFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,179 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+git_test.go - tests for GitSource
+
+*/
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prepairGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=cia", "GIT_AUTHOR_EMAIL=cia@example.com",
+			"GIT_COMMITTER_NAME=cia", "GIT_COMMITTER_EMAIL=cia@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(repo, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	write("a.txt", "a")
+	write("b.txt", "b")
+	write("src/c.txt", "c")
+	run("add", ".")
+	run("commit", "-q", "-m", "base")
+	run("tag", "base")
+	write("b.txt", "b2")
+	write("src/d.txt", "d")
+	run("rm", "-q", "a.txt")
+	run("add", ".")
+	run("commit", "-q", "-m", "change")
+	write("untracked.txt", "u")
+	return repo
+}
+
+func TestGitSourceFiles(t *testing.T) {
+	repo := prepairGitRepo(t)
+	testCases := []struct {
+		source   *GitSource
+		expected []string
+	}{
+		{NewGitSource(repo), []string{"b.txt", filepath.Join("src", "c.txt"), filepath.Join("src", "d.txt")}},
+		{NewGitSource(repo).SetRef("base"), []string{"a.txt", "b.txt", filepath.Join("src", "c.txt")}},
+		{NewGitSource(filepath.Join(repo, "src")).SetBase("base"), []string{"b.txt", filepath.Join("src", "d.txt")}},
+	}
+	for i, tc := range testCases {
+		actual, err := tc.source.Files(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(actual)
+		if len(actual) != len(tc.expected) {
+			t.Errorf("%d: expected %v, but got %v", i, tc.expected, actual)
+			continue
+		}
+		for j := range actual {
+			if actual[j] != tc.expected[j] {
+				t.Errorf("%d: expected %v, but got %v", i, tc.expected, actual)
+				break
+			}
+		}
+	}
+}
+
+func TestGitSourceScan(t *testing.T) {
+	repo := prepairGitRepo(t)
+	if err := os.WriteFile(filepath.Join(repo, "b.txt"), []byte("uncommitted"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := &contentClient{fakeClient: newFakeClient(), contents: make(map[string]string)}
+	report := NewReport()
+	app := NewApplication(client).
+		SetPause(1 * time.Millisecond).
+		SetReport(report).
+		SetSkipFolders([]string{"src"})
+	app.SetPrescanJobs(1).SetSubmitJobs(1)
+	err := app.Run(context.Background(), NewGitSource(repo).SetBase("base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := report.Records()
+	if len(records) != 1 || records[0].Path != "b.txt" {
+		t.Errorf("Expected only b.txt, but got %v", records)
+	}
+	if len(client.contents) != 1 {
+		t.Errorf("Wrong uploads: %v", client.uploads)
+	}
+	for _, content := range client.contents {
+		if content != "b2" {
+			t.Errorf("Expected committed content, but got %q", content)
+		}
+	}
+}
+
+func TestGitFSModTime(t *testing.T) {
+	repo := prepairGitRepo(t)
+	output, err := exec.Command("git", "-C", repo, "log", "-1", "--format=%ct", "base").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewGitFS(context.Background(), repo, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := strconv.FormatInt(info.ModTime().Unix(), 10)
+	if actual != strings.TrimSpace(string(output)) {
+		t.Errorf("Expected commit time %s, but got %s", output, actual)
+	}
+}
+
+func TestGitSourceWrongRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	_, err := NewGitSource(t.TempDir()).Files(context.Background())
+	if err == nil {
+		t.Errorf("No error for folder that is not git repository")
+	}
+}
+
+func TestGitSourceScanRef(t *testing.T) {
+	repo := prepairGitRepo(t)
+	client := &contentClient{fakeClient: newFakeClient(), contents: make(map[string]string)}
+	report := NewReport()
+	app := NewApplication(client).
+		SetPause(1 * time.Millisecond).
+		SetReport(report)
+	app.SetPrescanJobs(1).SetSubmitJobs(1)
+	err := app.Run(context.Background(), NewGitSource(repo).SetRef("base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := report.Records(); len(records) != 3 {
+		t.Errorf("Expected 3 records, but got %v", records)
+	}
+	actual := make([]string, 0, len(client.contents))
+	for _, content := range client.contents {
+		actual = append(actual, content)
+	}
+	sort.Strings(actual)
+	expected := []string{"a", "b", "c"}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected contents %v, but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,548 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+image.go - check files of container images
+
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrImageFormat      = errors.New("unsupported image format")
+	ErrImageCompression = errors.New("unsupported layer compression")
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ImageSource - check files of docker save tarball, OCI image layout folder or
+// OCI image layout tarball. Only files that are present in the final image are
+// checked. File paths are reported as image!layer digest/path in image
+type ImageSource struct {
+	path   string
+	tmpDir string
+}
+
+// NewImageSource - create source for image file or folder
+func NewImageSource(path string) *ImageSource {
+	return &ImageSource{path: path}
+}
+
+func (s *ImageSource) String() string {
+	return "image " + s.path
+}
+
+// imageLayer - layer blob name in the archive and its digest
+type imageLayer struct {
+	digest string
+	blob   string
+}
+
+// image - layers of the single image from lowest to topmost
+type image struct {
+	name   string
+	layers []imageLayer
+}
+
+// Scan - extract files of all images to temporary folder and pass them to application
+func (s *ImageSource) Scan(ctx context.Context, a *Application) error {
+	log.Printf("Process image: %s", s.path)
+	archive, err := openImageArchive(s.path)
+	if err != nil {
+		return fmt.Errorf("image %s: %w", s.path, err)
+	}
+	defer archive.Close()
+	images, err := imageList(archive)
+	if err != nil {
+		return fmt.Errorf("image %s: %w", s.path, err)
+	}
+	s.tmpDir, err = os.MkdirTemp("", "cia-image-")
+	if err != nil {
+		return err
+	}
+	for i, img := range images {
+		log.Printf("Process image %s: %d layers", img.name, len(img.layers))
+		hidden := newWhiteouts()
+		for j := len(img.layers) - 1; j >= 0; j-- {
+			dir := filepath.Join(s.tmpDir, strconv.Itoa(i), strconv.Itoa(j))
+			err := s.scanLayer(ctx, a, archive, img.layers[j], dir, hidden)
+			if err != nil {
+				return fmt.Errorf("image %s: layer %s: %w", s.path, img.layers[j].digest, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close - remove extracted files
+func (s *ImageSource) Close() error {
+	if s.tmpDir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.tmpDir)
+}
+
+// scanLayer - extract files of layer that are not hidden by upper layers
+func (s *ImageSource) scanLayer(ctx context.Context, a *Application, archive imageArchive, layer imageLayer, dir string, hidden *whiteouts) error {
+	blob, err := archive.Open(layer.blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	input, err := decompressLayer(blob)
+	if err != nil {
+		return err
+	}
+	layerHidden := newWhiteouts()
+	regular := make(map[string]*tar.Header)
+	extracted := make(map[string]string)
+	pending := make(map[string][]*tar.Header)
+	tarReader := tar.NewReader(input)
+	for {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, ok := entryName(header.Name)
+		if !ok {
+			continue
+		}
+		dirName, baseName := path.Split(name)
+		switch {
+		case baseName == whiteoutOpaque:
+			layerHidden.opaque[path.Clean(dirName)] = true
+			continue
+		case strings.HasPrefix(baseName, whiteoutPrefix):
+			layerHidden.deleted[path.Join(dirName, strings.TrimPrefix(baseName, whiteoutPrefix))] = true
+			continue
+		}
+		isRegular := header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA //nolint
+		if isRegular {
+			regular[name] = header
+		}
+		if hidden.Hidden(name) {
+			continue
+		}
+		if header.Typeflag != tar.TypeDir {
+			layerHidden.deleted[name] = true
+		}
+		if !isRegular && header.Typeflag != tar.TypeLink {
+			continue
+		}
+		if a.ShouldSkipFolder("/" + name) {
+			continue
+		}
+		if isRegular {
+			local := filepath.Join(dir, filepath.FromSlash(name))
+			if err := extractFile(tarReader, local, header.Size); err != nil {
+				return err
+			}
+			extracted[name] = local
+			s.addFile(a, layer, name, local, header)
+			continue
+		}
+		target, ok := entryName(header.Linkname)
+		targetHeader, found := regular[target]
+		if !ok || !found {
+			log.Printf("Ignore hard link %s to unknown file %s", name, header.Linkname)
+			continue
+		}
+		link := *targetHeader
+		link.Name = header.Name
+		if local, found := extracted[target]; found {
+			s.addFile(a, layer, name, local, &link)
+			continue
+		}
+		pending[target] = append(pending[target], &link)
+	}
+	hidden.Add(layerHidden)
+	if len(pending) == 0 {
+		return nil
+	}
+	return s.scanLinks(ctx, a, archive, layer, dir, pending)
+}
+
+// scanLinks - extract targets of hard links that are deleted by upper layers
+// or skipped and pass links to application
+func (s *ImageSource) scanLinks(ctx context.Context, a *Application, archive imageArchive, layer imageLayer, dir string, pending map[string][]*tar.Header) error {
+	blob, err := archive.Open(layer.blob)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	input, err := decompressLayer(blob)
+	if err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(input)
+	for len(pending) > 0 {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, ok := entryName(header.Name)
+		links, found := pending[name]
+		if !ok || !found || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA) { //nolint
+			continue
+		}
+		delete(pending, name)
+		local := filepath.Join(dir, filepath.FromSlash(name))
+		if err := extractFile(tarReader, local, header.Size); err != nil {
+			return err
+		}
+		for _, link := range links {
+			linkName, _ := entryName(link.Name)
+			s.addFile(a, layer, linkName, local, link)
+		}
+	}
+	return nil
+}
+
+// addFile - pass extracted file to application. Path is reported as
+// image!layer digest/path in image
+func (s *ImageSource) addFile(a *Application, layer imageLayer, name, local string, header *tar.Header) {
+	filePath := fmt.Sprintf("%s!%s/%s", s.path, layer.digest, name)
+	a.AddFile(NewVirtualFile(filePath, local, header.FileInfo()))
+}
+
+// entryName - return cleaned relative path of tar entry. False for paths
+// that point outside of archive
+func entryName(name string) (string, bool) {
+	name = path.Clean("/" + name)[1:]
+	return name, name != ""
+}
+
+func extractFile(input io.Reader, local string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0o700); err != nil {
+		return err
+	}
+	output, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(output, input, size)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func decompressLayer(input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(4)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("zstd: %w", ErrImageCompression)
+	}
+	return buffered, nil
+}
+
+// whiteouts - paths of lower layers that are deleted or replaced by upper layers
+type whiteouts struct {
+	deleted map[string]bool
+	opaque  map[string]bool
+}
+
+func newWhiteouts() *whiteouts {
+	return &whiteouts{
+		deleted: make(map[string]bool),
+		opaque:  make(map[string]bool),
+	}
+}
+
+// Hidden - return true if path is deleted or replaced by upper layers
+func (w *whiteouts) Hidden(name string) bool {
+	if w.deleted[name] {
+		return true
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if w.deleted[dir] || w.opaque[dir] {
+			return true
+		}
+		if dir == "." || dir == "/" {
+			return false
+		}
+	}
+}
+
+// Add - add whiteouts of upper layer
+func (w *whiteouts) Add(other *whiteouts) {
+	for name := range other.deleted {
+		w.deleted[name] = true
+	}
+	for name := range other.opaque {
+		w.opaque[name] = true
+	}
+}
+
+// imageArchive - docker save or OCI layout tarball or OCI layout folder
+type imageArchive interface {
+	Open(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+func openImageArchive(imagePath string) (imageArchive, error) {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return dirArchive(imagePath), nil
+	}
+	return openTarArchive(imagePath)
+}
+
+// dirArchive - OCI image layout folder
+type dirArchive string
+
+func (d dirArchive) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d dirArchive) Close() error {
+	return nil
+}
+
+// tarArchive - image tarball. Entries are read directly from tarball file
+type tarArchive struct {
+	file    *os.File
+	entries map[string]tarEntry
+}
+
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+func openTarArchive(imagePath string) (*tarArchive, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	archive := &tarArchive{
+		file:    file,
+		entries: make(map[string]tarEntry),
+	}
+	counter := &countingReader{reader: file}
+	tarReader := tar.NewReader(counter)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA { //nolint
+			continue
+		}
+		name, ok := entryName(header.Name)
+		if !ok {
+			continue
+		}
+		archive.entries[name] = tarEntry{offset: counter.count, size: header.Size}
+	}
+	return archive, nil
+}
+
+func (t *tarArchive) Open(name string) (io.ReadCloser, error) {
+	entry, ok := t.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return io.NopCloser(io.NewSectionReader(t.file, entry.offset, entry.size)), nil
+}
+
+func (t *tarArchive) Close() error {
+	return t.file.Close()
+}
+
+// countingReader - count number of bytes read to find entries offsets
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// imageList - return list of images in archive. docker save manifest.json is
+// used if present and OCI index.json otherwise
+func imageList(archive imageArchive) ([]image, error) {
+	manifest, err := archive.Open("manifest.json")
+	if err == nil {
+		defer manifest.Close()
+		return dockerImageList(archive, manifest)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	index, err := archive.Open("index.json")
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: neither manifest.json nor index.json found", ErrImageFormat)
+		}
+		return nil, err
+	}
+	defer index.Close()
+	return ociImageList(archive, index, "")
+}
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+type dockerConfig struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+func dockerImageList(archive imageArchive, input io.Reader) ([]image, error) {
+	var manifests []dockerManifest
+	if err := json.NewDecoder(input).Decode(&manifests); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	var images []image
+	for _, manifest := range manifests {
+		img := image{name: manifest.Config}
+		if len(manifest.RepoTags) > 0 {
+			img.name = manifest.RepoTags[0]
+		}
+		var config dockerConfig
+		if err := readJSON(archive, manifest.Config, &config); err != nil {
+			return nil, err
+		}
+		for i, blob := range manifest.Layers {
+			digest := blob
+			if len(config.RootFS.DiffIDs) == len(manifest.Layers) {
+				digest = config.RootFS.DiffIDs[i]
+			}
+			img.layers = append(img.layers, imageLayer{digest: digest, blob: blob})
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type ociIndex struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+func ociImageList(archive imageArchive, input io.Reader, name string) ([]image, error) {
+	var index ociIndex
+	if err := json.NewDecoder(input).Decode(&index); err != nil {
+		return nil, fmt.Errorf("index: %w", err)
+	}
+	var images []image
+	for _, manifest := range index.Manifests {
+		imageName := name
+		if ref, ok := manifest.Annotations["org.opencontainers.image.ref.name"]; ok {
+			imageName = ref
+		}
+		if imageName == "" {
+			imageName = manifest.Digest
+		}
+		blob, err := archive.Open(blobName(manifest.Digest))
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("Ignore missing manifest %s of %s", manifest.Digest, imageName)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var nested []image
+		if strings.Contains(manifest.MediaType, "index") || strings.Contains(manifest.MediaType, "manifest.list") {
+			nested, err = ociImageList(archive, blob, imageName)
+		} else {
+			nested, err = ociImage(blob, imageName)
+		}
+		blob.Close()
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", manifest.Digest, err)
+		}
+		images = append(images, nested...)
+	}
+	return images, nil
+}
+
+func ociImage(input io.Reader, name string) ([]image, error) {
+	var manifest ociIndex
+	if err := json.NewDecoder(input).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	img := image{name: name}
+	for _, layer := range manifest.Layers {
+		img.layers = append(img.layers, imageLayer{digest: layer.Digest, blob: blobName(layer.Digest)})
+	}
+	return []image{img}, nil
+}
+
+// blobName - return path of OCI blob with given digest
+func blobName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func readJSON(archive imageArchive, name string, v interface{}) error {
+	input, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if err := json.NewDecoder(input).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+image_test.go - tests for ImageSource
+
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tarItem struct {
+	name    string
+	content string
+}
+
+// tarBytes - return tarball with items followed by hard links. Content of
+// link item is the name of its target
+func tarBytes(t *testing.T, items []tarItem, links ...tarItem) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, item := range items {
+		header := &tar.Header{Name: item.name, Mode: 0o644, Size: int64(len(item.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(item.name, "/") {
+			header = &tar.Header{Name: item.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(item.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, link := range links {
+		header := &tar.Header{Name: link.name, Linkname: link.content, Mode: 0o644, Typeflag: tar.TypeLink}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func testLayers(t *testing.T) [][]byte {
+	t.Helper()
+	lower := tarBytes(t, []tarItem{
+		{"bin/", ""},
+		{"bin/a", "a1"},
+		{"etc/b", "b"},
+		{"etc/c", "c"},
+		{"dir/x", "x"},
+	}, []tarItem{
+		{"bin/link", "bin/a"},
+		{"etc/hard", "./etc/b"},
+		{"etc/missing", "etc/none"},
+	}...)
+	upper := gzipBytes(t, tarBytes(t, []tarItem{
+		{"./bin/a", "a2"},
+		{"etc/.wh.b", ""},
+		{"dir/.wh..wh..opq", ""},
+		{"dir/y", "y"},
+	}))
+	return [][]byte{lower, upper}
+}
+
+var expectedImageFiles = []string{"bin/a", "bin/link", "dir/y", "etc/c", "etc/hard"}
+
+// expectedImageContents - contents of files in final image. Hard links have
+// contents of their targets even if targets are deleted by upper layers
+var expectedImageContents = []string{"a1", "a2", "b", "c", "y"}
+
+func prepairDockerImage(t *testing.T) string {
+	t.Helper()
+	layers := testLayers(t)
+	config := mustJSON(t, map[string]interface{}{
+		"rootfs": map[string]interface{}{
+			"diff_ids": []string{digest(layers[0]), "sha256:upper"},
+		},
+	})
+	manifest := mustJSON(t, []dockerManifest{{
+		Config:   "config.json",
+		RepoTags: []string{"app:latest"},
+		Layers:   []string{"lower/layer.tar", "upper/layer.tar"},
+	}})
+	image := tarBytes(t, []tarItem{
+		{"manifest.json", manifest},
+		{"config.json", config},
+		{"lower/layer.tar", string(layers[0])},
+		{"upper/layer.tar", string(layers[1])},
+	})
+	imagePath := filepath.Join(t.TempDir(), "app.tar")
+	if err := os.WriteFile(imagePath, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return imagePath
+}
+
+func prepairOCIImage(t *testing.T) string {
+	t.Helper()
+	layout := t.TempDir()
+	writeBlob := func(data []byte) string {
+		d := digest(data)
+		blob := filepath.Join(layout, filepath.FromSlash(blobName(d)))
+		if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(blob, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	var layers []ociDescriptor
+	for _, layer := range testLayers(t) {
+		layers = append(layers, ociDescriptor{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: writeBlob(layer)})
+	}
+	manifest := writeBlob([]byte(mustJSON(t, ociIndex{Layers: layers})))
+	index := mustJSON(t, ociIndex{Manifests: []ociDescriptor{{
+		MediaType:   "application/vnd.oci.image.manifest.v1+json",
+		Digest:      manifest,
+		Annotations: map[string]string{"org.opencontainers.image.ref.name": "latest"},
+	}, {
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Digest:    "sha256:missing",
+	}}})
+	if err := os.WriteFile(filepath.Join(layout, "index.json"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return layout
+}
+
+func TestImageSource(t *testing.T) {
+	testCases := map[string]string{
+		"docker": prepairDockerImage(t),
+		"oci":    prepairOCIImage(t),
+	}
+	for name, imagePath := range testCases {
+		t.Run(name, func(t *testing.T) {
+			client := &contentClient{fakeClient: newFakeClient(), contents: make(map[string]string)}
+			report := NewReport()
+			app := NewApplication(client).
+				SetPause(1 * time.Millisecond).
+				SetReport(report)
+			app.SetPrescanJobs(1).SetSubmitJobs(1)
+			source := NewImageSource(imagePath)
+			err := app.Run(context.Background(), source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, record := range report.Records() {
+				if !strings.HasPrefix(record.Path, imagePath+"!sha256:") {
+					t.Errorf("Wrong path: %s", record.Path)
+				}
+				inImage := strings.TrimPrefix(record.Path, imagePath+"!")
+				actual = append(actual, inImage[strings.Index(inImage, "/")+1:])
+			}
+			sort.Strings(actual)
+			if strings.Join(actual, ",") != strings.Join(expectedImageFiles, ",") {
+				t.Errorf("Expected %v, but got %v", expectedImageFiles, actual)
+			}
+			var contents []string
+			for _, content := range client.contents {
+				contents = append(contents, content)
+			}
+			sort.Strings(contents)
+			if strings.Join(contents, ",") != strings.Join(expectedImageContents, ",") {
+				t.Errorf("Expected contents %v, but got %v", expectedImageContents, contents)
+			}
+			if _, err := os.Stat(source.tmpDir); !os.IsNotExist(err) {
+				t.Errorf("Temporary folder is not removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestImageSourceWrongFormat(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "wrong.tar")
+	if err := os.WriteFile(imagePath, tarBytes(t, []tarItem{{"file", "content"}}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := NewApplication(newFakeClient()).SetPause(1 * time.Millisecond)
+	err := app.Run(context.Background(), NewImageSource(imagePath))
+	if err == nil {
+		t.Errorf("No error for wrong image format")
+	}
+}
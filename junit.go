@@ -0,0 +1,121 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+junit.go - JUnit XML report output
+
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit - write report as JUnit XML with test case for each file
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{Name: "cia"}
+	for _, record := range r.Records() {
+		testCase := junitTestCase{
+			Name:      filepath.ToSlash(record.Path),
+			ClassName: "cia." + filepath.ToSlash(filepath.Dir(record.Path)),
+			SystemOut: junitDetails(record),
+		}
+		switch {
+		case !record.Pass:
+			testCase.Failure = &junitFailure{
+				Message: junitFailureMessage(record),
+				Type:    record.Verdict,
+				Text:    junitDetails(record),
+			}
+			suite.Failures++
+		case record.Filter == FilterSkip:
+			testCase.Skipped = &junitSkipped{Message: "not submitted according to filter rules"}
+			suite.Skipped++
+		case record.Filter == FilterExtract:
+			testCase.Skipped = &junitSkipped{Message: "archive members are checked separately"}
+			suite.Skipped++
+		case record.Verdict == "bigFile":
+			testCase.Skipped = &junitSkipped{Message: fmt.Sprintf("file size %d exceeds maximum file size", record.Size)}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+	}
+	document := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitTestSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitFailureMessage(record Record) string {
+	if record.Error != "" {
+		return fmt.Sprintf("%s: %s", record.Verdict, record.Error)
+	}
+	if record.Status == "" {
+		return record.Verdict
+	}
+	return fmt.Sprintf("%s: status %s, risk level %s", record.Verdict, record.Status, record.Risk)
+}
+
+func junitDetails(record Record) string {
+	details := fmt.Sprintf("SHA1: %s\nMIME: %s\nSize: %d\nFilter: %s\nStatus: %s\nRisk level: %s\nVerdict: %s\n",
+		record.SHA1, record.MIME, record.Size, record.Filter, record.Status, record.Risk, record.Verdict)
+	if record.CacheAge != "" {
+		details += fmt.Sprintf("Cache age: %s\n", record.CacheAge)
+	}
+	if record.Error != "" {
+		details += fmt.Sprintf("Error: %s\n", record.Error)
+	}
+	return details
+}
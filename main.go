@@ -18,11 +18,13 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/mpkondrashin/ddan"
@@ -30,54 +32,29 @@ import (
 )
 
 func main() {
-	log.Print("Started")
-	err := setupConfig()
+	err := RunCommand(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	analyzer, err := setupAnalyzer()
-	if err != nil {
-		log.Fatal(err)
-	}
-	app := NewApplication(analyzer)
-	app.SetPrescanJobs(viper.GetInt("analyzer.prescanJobs"))
-	app.SetSubmitJobs(viper.GetInt("analyzer.submitJobs"))
-	app.SetPause(viper.GetDuration("analyzer.pullInterval"))
-	app.SetMaxFileSize(viper.GetInt("analyzer.maxFileSize"))
-
-	filterPath := viper.GetString("filter")
-	if filterPath != "" {
-		filter, err := LoadFilter(filterPath)
-		if err != nil {
-			log.Fatal(err)
-		}
-		app.SetFilter(filter)
-	}
-
-	for _, each := range VerdictList {
-		app.SetAction(each, viper.GetBool("allow."+each))
-	}
-
-	skipFolders := viper.GetStringSlice("skip")
-	if skipFolders != nil {
-		app.SetSkipFolders(skipFolders)
-	}
-
-	err = app.Run(viper.GetString("folder"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Print("Done")
 }
 
-func setupConfig() error {
-	viper.SetConfigName("cia")
+// setupConfig - read given configuration file or cia.yaml from current folder if
+// it exists. Without configuration file only flags and environment variables are used
+func setupConfig(configFile string) error {
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("cia")
+		viper.AddConfigPath(".")
+	}
 	err := viper.ReadInConfig()
 	if err != nil {
-		log.Fatal(fmt.Errorf("Fatal error config file: %w \n", err))
+		var notFound viper.ConfigFileNotFoundError
+		if configFile != "" || !errors.As(err, &notFound) {
+			return fmt.Errorf("config file: %w", err)
+		}
+		log.Print("cia.yaml not found. Using flags and environment variables only")
 	}
 
 	viper.SetEnvPrefix("CIA")
@@ -86,23 +63,61 @@ func setupConfig() error {
 
 	viper.SetDefault("analyzer.maxFileSize", "50000000")
 	viper.SetDefault("analyzer.pullInterval", "60s")
+	viper.SetDefault("analyzer.checkBatch", "100")
+	viper.SetDefault("analyzer.checkWindow", "1s")
+	viper.SetDefault("analyzer.pollBatch", "100")
+	viper.SetDefault("analyzer.scanTimeout", "0s")
+	viper.SetDefault("analyzer.waitTimeout", "0s")
 	viper.SetDefault("analyzer.prescanJobs", "16")
 	viper.SetDefault("analyzer.submitJobs", "60")
+	viper.SetDefault("analyzer.fileRetries", "0")
+	viper.SetDefault("analyzer.hashOnly", "false")
 	viper.SetDefault("analyzer.ignoreTLSError", "false")
 	viper.SetDefault("analyzer.productName", "cia")
 	viper.SetDefault("analyzer.sourceID", "500")
 	viper.SetDefault("analyzer.sourceName", "pipline")
+	viper.SetDefault("analyzer.retry.attempts", "3")
+	viper.SetDefault("analyzer.retry.backoff", "1s")
+	viper.SetDefault("analyzer.retry.maxBackoff", "30s")
+	viper.SetDefault("analyzer.retry.jitter", "0.2")
 
-	viper.SetDefault("action.highRisk", "false")
-	viper.SetDefault("action.mediumRisk", "false")
-	viper.SetDefault("action.lowRisk", "false")
-	viper.SetDefault("action.error", "false")
-	viper.SetDefault("action.unscannable", "true")
-	viper.SetDefault("action.timeout", "false")
-	viper.SetDefault("action.bigFile", "true")
+	viper.SetDefault("mime", "builtin")
+
+	viper.SetDefault("s3.region", "us-east-1")
+	viper.SetDefault("s3.pathStyle", "true")
+
+	viper.SetDefault("extract.enable", "false")
+	viper.SetDefault("extract.maxDepth", "3")
+	viper.SetDefault("extract.maxSize", "1GB")
+	viper.SetDefault("extract.maxFiles", "10000")
+	viper.SetDefault("extract.maxRatio", "100")
+
+	viper.SetDefault("allow.highRisk", "false")
+	viper.SetDefault("allow.mediumRisk", "false")
+	viper.SetDefault("allow.lowRisk", "false")
+	viper.SetDefault("allow.error", "false")
+	viper.SetDefault("allow.unscannable", "false")
+	viper.SetDefault("allow.timeout", "false")
+	viper.SetDefault("allow.bigFile", "false")
+	viper.SetDefault("allow.unknown", "false")
 	return nil
 }
 
+func setupReport() *Report {
+	var report *Report
+	for format := range reportWriters {
+		path := viper.GetString("report." + format)
+		if path == "" {
+			continue
+		}
+		if report == nil {
+			report = NewReport()
+		}
+		report.SetOutput(format, path)
+	}
+	return report
+}
+
 func setupAnalyzer() (ddan.ClientInterace, error) {
 	productName := viper.GetString("analyzer.productName")
 	hostname, err := os.Hostname()
@@ -110,18 +125,14 @@ func setupAnalyzer() (ddan.ClientInterace, error) {
 		return nil, fmt.Errorf("setup Analyzer: %w", err)
 	}
 
-	var analyzer ddan.ClientInterace
-	db, dbURL := setupCacheDatabase()
-	if db != nil {
-		ddanCache, err := ddan.NewCache(db, dbURL)
-		if err != nil {
-			return nil, fmt.Errorf("setup Analyzer: %w", err)
-		}
-		analyzer = ddan.NewCachedClient(productName, hostname, ddanCache)
-	} else {
+	verdictCache, err := setupVerdictCache()
+	if err != nil {
+		return nil, fmt.Errorf("setup Analyzer: %w", err)
+	}
+	if verdictCache == nil {
 		log.Print("WARNING: cache not configured. CIA will run with dramatically reduced performance")
-		analyzer = ddan.NewClient(productName, hostname)
 	}
+	analyzer := ddan.NewClient(productName, hostname)
 	URL, err := url.Parse(viper.GetString("analyzer.url"))
 	if err != nil {
 		return nil, fmt.Errorf("setup Analyzer: analyzer.url value: %w", err)
@@ -140,20 +151,106 @@ func setupAnalyzer() (ddan.ClientInterace, error) {
 	analyzer.SetUUID(
 		viper.GetString("analyzer.clientUUID"),
 	)
-	return analyzer, nil
+
+	retryClient := NewRetryClient(analyzer).
+		SetAttempts(viper.GetInt("analyzer.retry.attempts")).
+		SetBackoff(viper.GetDuration("analyzer.retry.backoff"), viper.GetDuration("analyzer.retry.maxBackoff")).
+		SetJitter(viper.GetFloat64("analyzer.retry.jitter"))
+	if viper.IsSet("analyzer.retry.statusCodes") {
+		retryClient.SetRetryableStatusCodes(viper.GetIntSlice("analyzer.retry.statusCodes"))
+	}
+	if viper.IsSet("analyzer.retry.errors") {
+		retryClient.SetRetryableErrors(viper.GetStringSlice("analyzer.retry.errors"))
+	}
+	if verdictCache != nil {
+		expiry, err := setupVerdictExpiry()
+		if err != nil {
+			return nil, fmt.Errorf("setup Analyzer: %w", err)
+		}
+		cachedClient := NewVerdictCachedClient(retryClient, verdictCache)
+		for riskLevel, age := range expiry {
+			cachedClient.SetExpiry(riskLevel, age)
+		}
+		return cachedClient, nil
+	}
+	return retryClient, nil
+}
+
+// setupVerdictCache - open configured cache. Returns nil if cache is not configured
+func setupVerdictCache() (VerdictCache, error) {
+	switch viper.GetString("cache.type") {
+	case "memory":
+		return NewMemoryCache(), nil
+	case "file":
+		viper.SetDefault("cache.path", "cia_cache.jsonl")
+		return OpenFileCache(viper.GetString("cache.path"))
+	}
+	cache, err := setupSQLCache()
+	if err != nil || cache == nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// setupSQLCache - open cache in PostgreSQL or SQLite database. Returns nil if
+// cache database is not configured
+func setupSQLCache() (*SQLCache, error) {
+	db, dbURL, err := setupCacheDatabase()
+	if err != nil || db == nil {
+		return nil, err
+	}
+	dialect := DialectPostgres
+	if strings.HasPrefix(viper.GetString("cache.type"), "sqlite") {
+		dialect = DialectSQLite
+	}
+	cache, err := OpenSQLCache(db, dbURL, dialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// setupVerdictExpiry - read cache.expire ages of verdicts by risk level. Risk levels
+// without age or with "never" value do not expire
+func setupVerdictExpiry() (map[ddan.Rating]time.Duration, error) {
+	expiry := make(map[ddan.Rating]time.Duration)
+	for _, riskLevel := range []ddan.Rating{
+		ddan.RatingUnsupported,
+		ddan.RatingNoRiskFound,
+		ddan.RatingLowRisk,
+		ddan.RatingMediumRisk,
+		ddan.RatingHighRisk,
+	} {
+		key := "cache.expire." + RiskLevelVerdict(riskLevel)
+		value := viper.GetString(key)
+		if value == "" || strings.EqualFold(value, "never") {
+			continue
+		}
+		age, err := ParseAge(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		expiry[riskLevel] = time.Duration(age)
+	}
+	return expiry, nil
 }
 
-func setupCacheDatabase() (*sql.DB, string) {
-	if viper.Get("cache") == nil {
-		return nil, ""
+func setupCacheDatabase() (*sql.DB, string, error) {
+	if viper.Get("cache") == nil && viper.GetString("cache.type") == "" {
+		return nil, "", nil
 	}
 	switch viper.GetString("cache.type") {
 	case "":
-		log.Fatal("cia.yaml: cache.type is missing")
+		return nil, "", errors.New("cia.yaml: cache.type is missing")
 	case "postgres", "postgresql":
-		return setupPostgreSQLCache()
+		db, dbURL := setupPostgreSQLCache()
+		return db, dbURL, nil
+	case "sqlite", "sqlite3":
+		return setupSQLiteCache()
+	case "memory", "file":
+		return nil, "", nil
 	default:
-		log.Fatalf("cia.yaml: cache.type %s is not supported", viper.GetString("cache.type"))
+		return nil, "", fmt.Errorf("cia.yaml: cache.type %s is not supported", viper.GetString("cache.type"))
 	}
-	return nil, ""
 }
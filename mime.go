@@ -0,0 +1,187 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+mime.go - detection of true file type
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/h2non/filetype"
+)
+
+var ErrUnknownMimeDetector = errors.New("unknown MIME detector")
+
+// mimeHeaderSize - number of bytes to read from the file for builtin MIME detection
+const mimeHeaderSize = 8192
+
+// MimeDetector - detects MIME type of the file
+type MimeDetector interface {
+	Detect(path string) (string, error)
+}
+
+var mimeDetectors = map[string]func() (MimeDetector, error){
+	"builtin":  func() (MimeDetector, error) { return BuiltinMimeDetector{}, nil },
+	"file":     func() (MimeDetector, error) { return FileMimeDetector{}, nil },
+	"libmagic": NewLibmagicMimeDetector,
+}
+
+var mimeDetector MimeDetector = BuiltinMimeDetector{}
+
+// SetMimeDetector - choose MIME detection backend by name: builtin, file or libmagic
+func SetMimeDetector(name string) error {
+	newDetector, ok := mimeDetectors[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownMimeDetector)
+	}
+	detector, err := newDetector()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	mimeDetector = detector
+	return nil
+}
+
+// FileMimeDetector - detect MIME type using file command
+type FileMimeDetector struct{}
+
+// Detect - run file command for given path
+func (FileMimeDetector) Detect(path string) (string, error) {
+	options := []string{"--mime-type", "--brief", path}
+	cmd := exec.Command("file", options...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %v: %w", "file", options, err)
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
+
+// BuiltinMimeDetector - detect MIME type without external tools
+type BuiltinMimeDetector struct{}
+
+// mimeCompatibility - builtin MIME types names that are reported differently by file command
+var mimeCompatibility = map[string]string{
+	"application/vnd.microsoft.portable-executable": "application/x-dosexec",
+	"application/x-rar-compressed":                  "application/x-rar",
+	"application/x-unix-archive":                    "application/x-archive",
+	"application/x-deb":                             "application/vnd.debian.binary-package",
+	"application/x-msdownload":                      "application/x-dosexec",
+	"application/x-sqlite3":                         "application/vnd.sqlite3",
+}
+
+// interpreterMime - MIME types of scripts by interpreter in shebang line
+var interpreterMime = map[string]string{
+	"sh":     "text/x-shellscript",
+	"bash":   "text/x-shellscript",
+	"dash":   "text/x-shellscript",
+	"ksh":    "text/x-shellscript",
+	"zsh":    "text/x-shellscript",
+	"csh":    "text/x-shellscript",
+	"tcsh":   "text/x-shellscript",
+	"python": "text/x-script.python",
+	"perl":   "text/x-perl",
+	"ruby":   "text/x-ruby",
+	"php":    "text/x-php",
+	"node":   "application/javascript",
+	"awk":    "text/x-awk",
+	"tclsh":  "text/x-tcl",
+	"lua":    "text/x-lua",
+}
+
+// Detect - detect MIME type by file signature
+func (BuiltinMimeDetector) Detect(path string) (string, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("detect MIME type: %w", err)
+	}
+	defer input.Close()
+	header, err := readHeader(input)
+	if err != nil {
+		return "", fmt.Errorf("detect MIME type: %s: %w", path, err)
+	}
+	return DetectMime(header), nil
+}
+
+// readHeader - read first bytes of file for MIME type detection
+func readHeader(input io.Reader) ([]byte, error) {
+	header := make([]byte, mimeHeaderSize)
+	n, err := io.ReadFull(input, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// DetectMime - detect MIME type by file header. Returned names are compatible with file command
+func DetectMime(header []byte) string {
+	if len(header) == 0 {
+		return "application/x-empty"
+	}
+	kind, err := filetype.Match(header)
+	if err == nil && kind != filetype.Unknown {
+		if compatible, ok := mimeCompatibility[kind.MIME.Value]; ok {
+			return compatible
+		}
+		return kind.MIME.Value
+	}
+	if machOMime(header) {
+		return "application/x-mach-binary"
+	}
+	if mime := scriptMime(header); mime != "" {
+		return mime
+	}
+	mime := http.DetectContentType(header)
+	if i := strings.Index(mime, ";"); i != -1 {
+		mime = mime[:i]
+	}
+	return mime
+}
+
+// machOMime - return true for macOS executables
+func machOMime(header []byte) bool {
+	if len(header) < 8 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(header) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return true
+	case 0xcafebabe:
+		// Universal binary has few architectures, while Java class has version 45 and above here
+		return binary.BigEndian.Uint32(header[4:]) < 20
+	}
+	return false
+}
+
+func scriptMime(header []byte) string {
+	if !bytes.HasPrefix(header, []byte("#!")) {
+		return ""
+	}
+	line, _ := bufio.NewReader(bytes.NewReader(header[2:])).ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "text/plain"
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	interpreter = strings.TrimRight(interpreter, "0123456789.")
+	if mime, ok := interpreterMime[interpreter]; ok {
+		return mime
+	}
+	return "text/plain"
+}
@@ -0,0 +1,60 @@
+//go:build libmagic
+
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+mime_libmagic.go - MIME type detection using libmagic. Build with -tags libmagic
+
+*/
+
+package main
+
+/*
+#cgo LDFLAGS: -lmagic
+#include <stdlib.h>
+#include <magic.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var ErrLibmagic = errors.New("libmagic error")
+
+// LibmagicMimeDetector - detect MIME type using libmagic library
+type LibmagicMimeDetector struct {
+	mx     sync.Mutex
+	cookie C.magic_t
+}
+
+// NewLibmagicMimeDetector - open libmagic and load default magic database
+func NewLibmagicMimeDetector() (MimeDetector, error) {
+	cookie := C.magic_open(C.MAGIC_MIME_TYPE)
+	if cookie == nil {
+		return nil, fmt.Errorf("magic_open: %w", ErrLibmagic)
+	}
+	if C.magic_load(cookie, nil) != 0 {
+		err := fmt.Errorf("magic_load: %w: %s", ErrLibmagic, C.GoString(C.magic_error(cookie)))
+		C.magic_close(cookie)
+		return nil, err
+	}
+	return &LibmagicMimeDetector{cookie: cookie}, nil
+}
+
+// Detect - detect MIME type of file. libmagic cookie is not thread safe, so calls are serialized
+func (d *LibmagicMimeDetector) Detect(path string) (string, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	mime := C.magic_file(d.cookie, cPath)
+	if mime == nil {
+		return "", fmt.Errorf("%s: %w: %s", path, ErrLibmagic, C.GoString(C.magic_error(d.cookie)))
+	}
+	return C.GoString(mime), nil
+}
@@ -0,0 +1,20 @@
+//go:build !libmagic
+
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+mime_nolibmagic.go - libmagic MIME detector placeholder for builds without libmagic
+
+*/
+
+package main
+
+import "errors"
+
+var ErrNoLibmagic = errors.New("CIA is built without libmagic support. Rebuild with -tags libmagic")
+
+// NewLibmagicMimeDetector - return error as libmagic is not available
+func NewLibmagicMimeDetector() (MimeDetector, error) {
+	return nil, ErrNoLibmagic
+}
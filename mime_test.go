@@ -0,0 +1,84 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+mime_test.go - tests for builtin MIME detection
+
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBuiltinMimeDetector(t *testing.T) {
+	testingFolder := "testing_filter"
+	testCases := []struct {
+		fileName string
+		expected string
+	}{
+		{"archive.gz", "application/gzip"},
+		{"archive.zip", "application/zip"},
+		{"hello", "application/x-executable"},
+		{"info.txt", "text/plain"},
+		{"python.py", "text/plain"},
+		{"shell.sh", "text/x-shellscript"},
+		{"tiny", "application/x-mach-binary"},
+		{"tiny.c", "text/plain"},
+		{"win32.exe", "application/x-dosexec"},
+	}
+	detector := BuiltinMimeDetector{}
+	for _, tc := range testCases {
+		actual, err := detector.Detect(filepath.Join(testingFolder, tc.fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected %s, but got %s", tc.fileName, tc.expected, actual)
+		}
+	}
+}
+
+func TestScriptMime(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{"#!/bin/sh\n", "text/x-shellscript"},
+		{"#!/usr/bin/env python3\n", "text/x-script.python"},
+		{"#!/usr/bin/perl -w\n", "text/x-perl"},
+		{"#!/opt/unknown\n", "text/plain"},
+		{"echo\n", ""},
+	}
+	for _, tc := range testCases {
+		actual := scriptMime([]byte(tc.header))
+		if actual != tc.expected {
+			t.Errorf("%q: expected %s, but got %s", tc.header, tc.expected, actual)
+		}
+	}
+}
+
+func TestDetectMimeText(t *testing.T) {
+	for _, text := range []string{
+		"int total(x)\n",
+		"import foo\n",
+		"#include <stdio.h>\nint main() {}\n",
+		"Just some text\n",
+	} {
+		actual := DetectMime([]byte(text))
+		if actual != "text/plain" {
+			t.Errorf("%q: expected text/plain, but got %s", text, actual)
+		}
+	}
+}
+
+func TestSetMimeDetector(t *testing.T) {
+	if err := SetMimeDetector("unknown"); err == nil {
+		t.Errorf("Expected error for unknown detector")
+	}
+	if err := SetMimeDetector("builtin"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+poller.go - batch polling of Analyzer for analysis results
+
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+type pollResult struct {
+	report ddan.BriefReport
+	err    error
+}
+
+// Poller - central poller that requests brief reports for all pending samples in batches
+type Poller struct {
+	analyzer  ddan.ClientInterace
+	interval  time.Duration
+	batchSize int
+	mx        sync.Mutex
+	pending   map[string][]chan pollResult
+	wake      chan struct{}
+}
+
+// NewPoller - create poller for given analyzer
+func NewPoller(analyzer ddan.ClientInterace, interval time.Duration) *Poller {
+	return &Poller{
+		analyzer:  analyzer,
+		interval:  interval,
+		batchSize: 100,
+		pending:   make(map[string][]chan pollResult),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// SetBatchSize - set maximum number of samples in single request
+func (p *Poller) SetBatchSize(batchSize int) *Poller {
+	if batchSize > 0 {
+		p.batchSize = batchSize
+	}
+	return p
+}
+
+// Wait - wait until analysis of sample defined by sha1 is finished
+func (p *Poller) Wait(ctx context.Context, sha1 string) (ddan.BriefReport, error) {
+	result := make(chan pollResult, 1)
+	p.mx.Lock()
+	if len(p.pending) == 0 {
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	p.pending[sha1] = append(p.pending[sha1], result)
+	p.mx.Unlock()
+	select {
+	case r := <-result:
+		return r.report, r.err
+	case <-ctx.Done():
+		p.cancel(sha1, result)
+		return ddan.BriefReport{}, ContextError(ctx)
+	}
+}
+
+func (p *Poller) cancel(sha1 string, result chan pollResult) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	waiters := p.pending[sha1]
+	for i, each := range waiters {
+		if each == result {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(p.pending, sha1)
+		return
+	}
+	p.pending[sha1] = waiters
+}
+
+// Run - poll Analyzer each interval until context is done. When samples start
+// waiting while there were no pending samples, Analyzer is polled immediately
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+			p.Poll(ctx)
+			ticker.Reset(p.interval)
+		case <-ticker.C:
+			p.Poll(ctx)
+		}
+	}
+}
+
+// Poll - request brief reports for all pending samples
+func (p *Poller) Poll(ctx context.Context) {
+	p.mx.Lock()
+	sha1List := make([]string, 0, len(p.pending))
+	for sha1 := range p.pending {
+		sha1List = append(sha1List, sha1)
+	}
+	p.mx.Unlock()
+	for start := 0; start < len(sha1List); start += p.batchSize {
+		end := start + p.batchSize
+		if end > len(sha1List) {
+			end = len(sha1List)
+		}
+		p.pollBatch(ctx, sha1List[start:end])
+	}
+}
+
+func (p *Poller) pollBatch(ctx context.Context, batch []string) {
+	briefReport, err := p.analyzer.GetBriefReport(ctx, batch)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Get brief report for %d samples: %v", len(batch), err)
+		for _, sha1 := range batch {
+			p.deliver(sha1, pollResult{err: fmt.Errorf("get brief report: %w", err)})
+		}
+		return
+	}
+	if len(briefReport.Reports) != len(batch) {
+		log.Printf("Got %d brief reports for %d samples", len(briefReport.Reports), len(batch))
+		for _, sha1 := range batch {
+			p.deliver(sha1, pollResult{err: fmt.Errorf("%s: %w", sha1, ErrNoReport)})
+		}
+		return
+	}
+	// Reports are returned in the same order as samples were requested
+	for i, sha1 := range batch {
+		report := briefReport.Reports[i]
+		switch report.SampleStatus {
+		case ddan.StatusArrived, ddan.StatusProcessing:
+			continue
+		}
+		p.deliver(sha1, pollResult{report: report})
+	}
+}
+
+func (p *Poller) deliver(sha1 string, result pollResult) {
+	p.mx.Lock()
+	waiters := p.pending[sha1]
+	delete(p.pending, sha1)
+	p.mx.Unlock()
+	for _, each := range waiters {
+		each <- result
+	}
+}
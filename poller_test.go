@@ -0,0 +1,172 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+poller_test.go - tests for Poller
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+type pollingClient struct {
+	ddan.ClientInterace
+	mx       sync.Mutex
+	requests [][]string
+}
+
+func (c *pollingClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.requests = append(c.requests, sha1List)
+	result := &ddan.BriefReports{}
+	for range sha1List {
+		status := ddan.StatusProcessing
+		if len(c.requests) > 1 {
+			status = ddan.StatusDone
+		}
+		result.Reports = append(result.Reports, ddan.BriefReport{
+			SampleStatus: status,
+			RiskLevel:    ddan.RatingNoRiskFound,
+		})
+	}
+	return result, nil
+}
+
+func TestPollerBatch(t *testing.T) {
+	client := &pollingClient{}
+	poller := NewPoller(client, time.Millisecond).SetBatchSize(3)
+	sha1List := []string{"1", "2", "3", "4", "5"}
+	var wg sync.WaitGroup
+	wg.Add(len(sha1List))
+	for _, sha1 := range sha1List {
+		go func(sha1 string) {
+			defer wg.Done()
+			report, err := poller.Wait(context.Background(), sha1)
+			if err != nil {
+				t.Error(err)
+			}
+			if report.SampleStatus != ddan.StatusDone {
+				t.Errorf("%s: expected %v, but got %v", sha1, ddan.StatusDone, report.SampleStatus)
+			}
+		}(sha1)
+	}
+	for {
+		poller.mx.Lock()
+		count := len(poller.pending)
+		poller.mx.Unlock()
+		if count == len(sha1List) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	poller.Poll(context.Background())
+	poller.Poll(context.Background())
+	wg.Wait()
+	if len(client.requests) != 3 {
+		t.Errorf("Expected 3 requests, but got %d", len(client.requests))
+	}
+	if len(client.requests[0]) != 3 {
+		t.Errorf("Expected batch of 3 samples, but got %d", len(client.requests[0]))
+	}
+}
+
+func TestPollerCancel(t *testing.T) {
+	poller := NewPoller(&pollingClient{}, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := poller.Wait(ctx, "1")
+	if err != ErrScanTimeout {
+		t.Errorf("Expected %v, but got %v", ErrScanTimeout, err)
+	}
+	if len(poller.pending) != 0 {
+		t.Errorf("Expected no pending samples, but got %d", len(poller.pending))
+	}
+}
+
+// ratingClient - fake client that returns reports with predefined ratings in
+// order of requested samples
+type ratingClient struct {
+	ddan.ClientInterace
+	ratings map[string]ddan.Rating
+	missing bool
+}
+
+func (c *ratingClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	result := &ddan.BriefReports{}
+	for _, sha1 := range sha1List {
+		result.Reports = append(result.Reports, ddan.BriefReport{
+			SampleStatus: ddan.StatusDone,
+			RiskLevel:    c.ratings[sha1],
+		})
+	}
+	if c.missing {
+		result.Reports = result.Reports[1:]
+	}
+	return result, nil
+}
+
+func testPollerRatings(t *testing.T, client *ratingClient, check func(sha1 string, report ddan.BriefReport, err error)) {
+	t.Helper()
+	poller := NewPoller(client, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(len(client.ratings))
+	for sha1 := range client.ratings {
+		go func(sha1 string) {
+			defer wg.Done()
+			report, err := poller.Wait(ctx, sha1)
+			check(sha1, report, err)
+		}(sha1)
+	}
+	for {
+		poller.mx.Lock()
+		count := len(poller.pending)
+		poller.mx.Unlock()
+		if count == len(client.ratings) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go poller.Run(ctx)
+	wg.Wait()
+}
+
+func TestPollerImmediate(t *testing.T) {
+	client := &ratingClient{ratings: map[string]ddan.Rating{
+		"aa": ddan.RatingLowRisk,
+		"bb": ddan.RatingMediumRisk,
+		"cc": ddan.RatingHighRisk,
+	}}
+	testPollerRatings(t, client, func(sha1 string, report ddan.BriefReport, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", sha1, err)
+			return
+		}
+		if report.RiskLevel != client.ratings[sha1] {
+			t.Errorf("%s: expected %v, but got %v", sha1, client.ratings[sha1], report.RiskLevel)
+		}
+	})
+}
+
+func TestPollerMissingReport(t *testing.T) {
+	client := &ratingClient{ratings: map[string]ddan.Rating{
+		"aa": ddan.RatingLowRisk,
+		"bb": ddan.RatingHighRisk,
+	}, missing: true}
+	testPollerRatings(t, client, func(sha1 string, report ddan.BriefReport, err error) {
+		if !errors.Is(err, ErrNoReport) {
+			t.Errorf("%s: expected %v, but got %v", sha1, ErrNoReport, err)
+		}
+	})
+}
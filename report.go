@@ -0,0 +1,232 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+report.go - structured scan report
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+var ErrUnknownReportFormat = errors.New("unknown report format")
+
+const (
+	FilterNone    = "none"
+	FilterSubmit  = "submit"
+	FilterSkip    = "skip"
+	FilterExtract = "extract"
+)
+
+// Record - outcome of checking of the single file
+type Record struct {
+	Path      string `json:"path"`
+	SHA1      string `json:"sha1,omitempty"`
+	MIME      string `json:"mime,omitempty"`
+	Size      int64  `json:"size"`
+	Filter    string `json:"filter"`
+	Status    string `json:"status,omitempty"`
+	RiskLevel *int   `json:"riskLevel,omitempty"`
+	Risk      string `json:"risk,omitempty"`
+	CacheAge  string `json:"cacheAge,omitempty"`
+	Verdict   string `json:"verdict,omitempty"`
+	Pass      bool   `json:"pass"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewRecord - create report record for processed file
+func NewRecord(file *File) Record {
+	r := Record{
+		Path:    file.Path,
+		SHA1:    file.sha1,
+		MIME:    file.mime,
+		Filter:  file.Filter,
+		Verdict: file.Verdict,
+		Pass:    file.Pass,
+	}
+	if file.Err != nil {
+		r.Error = file.Err.Error()
+	}
+	if file.Info != nil {
+		r.Size = file.Info.Size()
+	}
+	// MIME type is detected only for files which contents were read to get SHA1.
+	// Skipped files get it only if filter already needed it
+	if r.MIME == "" && file.sha1 != "" {
+		if mime, err := file.Mime(); err == nil {
+			r.MIME = mime
+		}
+	}
+	if file.Report != nil {
+		r.Status = ddan.StatusCodeNames[file.Report.SampleStatus]
+		riskLevel := int(file.Report.RiskLevel)
+		r.RiskLevel = &riskLevel
+		r.Risk = fmt.Sprint(file.Report.RiskLevel)
+	}
+	if file.CacheAge > 0 {
+		r.CacheAge = file.CacheAge.Round(time.Second).String()
+	}
+	return r
+}
+
+// Report - collection of records for all processed files
+type Report struct {
+	mx      sync.Mutex
+	records []Record
+	outputs map[string]string
+}
+
+var reportWriters = map[string]func(*Report, io.Writer) error{
+	"json":  (*Report).WriteJSON,
+	"sarif": (*Report).WriteSARIF,
+	"junit": (*Report).WriteJUnit,
+}
+
+// NewReport - create empty report
+func NewReport() *Report {
+	return &Report{
+		outputs: make(map[string]string),
+	}
+}
+
+// SetOutput - write report in given format to given file path on Save
+func (r *Report) SetOutput(format, path string) *Report {
+	r.outputs[format] = path
+	return r
+}
+
+// Add - add record for file
+func (r *Report) Add(file *File) {
+	record := NewRecord(file)
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.records = append(r.records, record)
+}
+
+// Records - return all records sorted by path
+func (r *Report) Records() []Record {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	records := make([]Record, len(r.records))
+	copy(records, r.records)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Path < records[j].Path
+	})
+	return records
+}
+
+// Inadmissible - return records for files that did not pass
+func (r *Report) Inadmissible() []Record {
+	var result []Record
+	for _, record := range r.Records() {
+		if !record.Pass {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// Save - write report to all configured outputs
+func (r *Report) Save() error {
+	formats := make([]string, 0, len(r.outputs))
+	for format := range r.outputs {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		err := r.SaveAs(format, r.outputs[format])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveAs - write report in given format to file
+func (r *Report) SaveAs(format, path string) error {
+	write, ok := reportWriters[format]
+	if !ok {
+		return fmt.Errorf("%s: %w", format, ErrUnknownReportFormat)
+	}
+	output, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("%s report: %w", format, err)
+	}
+	err = write(r, output)
+	if err != nil {
+		output.Close()
+		return fmt.Errorf("%s report: %s: %w", format, path, err)
+	}
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("%s report: %s: %w", format, path, err)
+	}
+	return nil
+}
+
+// SampleRecord - all paths of files with the same SHA1
+type SampleRecord struct {
+	SHA1    string   `json:"sha1"`
+	Verdict string   `json:"verdict,omitempty"`
+	Pass    bool     `json:"pass"`
+	Paths   []string `json:"paths"`
+}
+
+// Samples - group records by SHA1
+func Samples(records []Record) []SampleRecord {
+	var samples []SampleRecord
+	index := make(map[string]int)
+	for _, record := range records {
+		if record.SHA1 == "" {
+			continue
+		}
+		i, found := index[record.SHA1]
+		if !found {
+			i = len(samples)
+			index[record.SHA1] = i
+			samples = append(samples, SampleRecord{
+				SHA1:    record.SHA1,
+				Verdict: record.Verdict,
+				Pass:    record.Pass,
+			})
+		}
+		samples[i].Paths = append(samples[i].Paths, record.Path)
+	}
+	return samples
+}
+
+// WriteJSON - write report as JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	records := r.Records()
+	inadmissible := 0
+	for _, record := range records {
+		if !record.Pass {
+			inadmissible++
+		}
+	}
+	document := struct {
+		Total        int            `json:"total"`
+		Inadmissible int            `json:"inadmissible"`
+		Files        []Record       `json:"files"`
+		Samples      []SampleRecord `json:"samples"`
+	}{
+		Total:        len(records),
+		Inadmissible: inadmissible,
+		Files:        records,
+		Samples:      Samples(records),
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
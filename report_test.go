@@ -0,0 +1,147 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+report_test.go - tests for Report
+
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+func reportTestFile(t *testing.T, fileName string, report *ddan.BriefReport, verdict string, pass bool) *File {
+	t.Helper()
+	file, err := NewFile(filepath.Join("testing_filter", fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.mime = "text/plain"
+	file.sha1 = "0000000000000000000000000000000000000000"
+	file.Filter = FilterSubmit
+	file.Report = report
+	file.Verdict = verdict
+	file.Pass = pass
+	return file
+}
+
+func TestNewRecordMime(t *testing.T) {
+	skipped := reportTestFile(t, "tiny.c", nil, "", true)
+	skipped.mime = ""
+	skipped.sha1 = ""
+	skipped.Filter = FilterSkip
+	if record := NewRecord(skipped); record.MIME != "" || skipped.mime != "" {
+		t.Errorf("MIME type is detected for skipped file: %q", record.MIME)
+	}
+	checked := reportTestFile(t, "tiny.c", nil, "", true)
+	checked.mime = ""
+	if record := NewRecord(checked); record.MIME != "text/plain" {
+		t.Errorf("Expected text/plain, but got %q", record.MIME)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	report := NewReport()
+	report.Add(reportTestFile(t, "tiny.c", &ddan.BriefReport{
+		SampleStatus: ddan.StatusDone,
+		RiskLevel:    ddan.RatingHighRisk,
+	}, "highRisk", false))
+	report.Add(reportTestFile(t, "info.txt", nil, "", true))
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Total        int      `json:"total"`
+		Inadmissible int      `json:"inadmissible"`
+		Files        []Record `json:"files"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Total != 2 || document.Inadmissible != 1 {
+		t.Errorf("Expected 2 files and 1 inadmissible, but got %d and %d", document.Total, document.Inadmissible)
+	}
+	first := document.Files[0]
+	if first.Path != filepath.Join("testing_filter", "info.txt") {
+		t.Errorf("Expected files sorted by path, but got %s first", first.Path)
+	}
+	if first.Size != 100 {
+		t.Errorf("Expected size 100, but got %d", first.Size)
+	}
+	second := document.Files[1]
+	if second.RiskLevel == nil || *second.RiskLevel != int(ddan.RatingHighRisk) {
+		t.Errorf("Expected risk level %d, but got %v", ddan.RatingHighRisk, second.RiskLevel)
+	}
+}
+
+func TestReportSARIF(t *testing.T) {
+	report := NewReport()
+	report.Add(reportTestFile(t, "win32.exe", &ddan.BriefReport{
+		SampleStatus: ddan.StatusDone,
+		RiskLevel:    ddan.RatingMediumRisk,
+	}, "mediumRisk", false))
+	report.Add(reportTestFile(t, "info.txt", nil, "", true))
+	var buf bytes.Buffer
+	if err := report.WriteSARIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" {
+		t.Errorf("Expected version 2.1.0, but got %s", log.Version)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, but got %d", len(results))
+	}
+	if results[0].RuleID != "mediumRisk" {
+		t.Errorf("Expected mediumRisk rule, but got %s", results[0].RuleID)
+	}
+	uri := results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI
+	if uri != "testing_filter/win32.exe" {
+		t.Errorf("Expected testing_filter/win32.exe, but got %s", uri)
+	}
+}
+
+func TestReportJUnit(t *testing.T) {
+	report := NewReport()
+	report.Add(reportTestFile(t, "win32.exe", &ddan.BriefReport{
+		SampleStatus: ddan.StatusDone,
+		RiskLevel:    ddan.RatingHighRisk,
+	}, "highRisk", false))
+	skipped := reportTestFile(t, "info.txt", nil, "", true)
+	skipped.Filter = FilterSkip
+	report.Add(skipped)
+	report.Add(reportTestFile(t, "tiny.c", &ddan.BriefReport{
+		SampleStatus: ddan.StatusDone,
+		RiskLevel:    ddan.RatingNoRiskFound,
+	}, VerdictNoRisk, true))
+	var buf bytes.Buffer
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var document junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.Tests != 3 || document.Failures != 1 || document.Skipped != 1 {
+		t.Errorf("Expected 3 tests, 1 failure and 1 skipped, but got %d, %d and %d",
+			document.Tests, document.Failures, document.Skipped)
+	}
+	for _, testCase := range document.Suites[0].Cases {
+		if testCase.Name == "testing_filter/win32.exe" && testCase.Failure == nil {
+			t.Errorf("Expected failure for %s", testCase.Name)
+		}
+	}
+}
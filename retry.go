@@ -0,0 +1,200 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+retry.go - retry Analyzer API calls with exponential backoff
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+// DefaultRetryableStatusCodes - HTTP status codes that are considered transient
+var DefaultRetryableStatusCodes = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// DefaultRetryableErrors - error message fragments that are considered transient
+var DefaultRetryableErrors = []string{
+	"connection reset",
+	"connection refused",
+	"timeout",
+}
+
+// RetryClient - Analyzer client that retries failed API calls
+type RetryClient struct {
+	ddan.ClientInterace
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	errors     []string
+	statuses   []*regexp.Regexp
+}
+
+var _ ddan.ClientInterace = &RetryClient{}
+
+// NewRetryClient - wrap client to retry failed API calls
+func NewRetryClient(client ddan.ClientInterace) *RetryClient {
+	return &RetryClient{
+		ClientInterace: client,
+		attempts:       3,
+		backoff:        time.Second,
+		maxBackoff:     30 * time.Second,
+		jitter:         0.2,
+		errors:         DefaultRetryableErrors,
+		statuses:       statusPatterns(DefaultRetryableStatusCodes),
+	}
+}
+
+// SetAttempts - set maximum number of attempts for each call
+func (c *RetryClient) SetAttempts(attempts int) *RetryClient {
+	c.attempts = attempts
+	return c
+}
+
+// SetBackoff - set delay before first retry and maximum delay between retries
+func (c *RetryClient) SetBackoff(backoff, maxBackoff time.Duration) *RetryClient {
+	c.backoff = backoff
+	c.maxBackoff = maxBackoff
+	return c
+}
+
+// SetJitter - set fraction of delay to be randomized
+func (c *RetryClient) SetJitter(jitter float64) *RetryClient {
+	c.jitter = jitter
+	return c
+}
+
+// SetRetryableErrors - set error message fragments that are considered transient
+func (c *RetryClient) SetRetryableErrors(fragments []string) *RetryClient {
+	c.errors = fragments
+	return c
+}
+
+// SetRetryableStatusCodes - set HTTP status codes that are considered transient
+func (c *RetryClient) SetRetryableStatusCodes(codes []int) *RetryClient {
+	c.statuses = statusPatterns(codes)
+	return c
+}
+
+// statusPatterns - patterns to find HTTP status code in error message either as
+// status line ("502 Bad Gateway") or after "status" word ("status code: 502"), so
+// digits in SHA1 or paths do not match
+func statusPatterns(codes []int) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(codes))
+	for i, code := range codes {
+		patterns[i] = regexp.MustCompile(fmt.Sprintf(`(?i)\b%d %s\b|\bstatus(?: code)?[ :=]+%d\b`,
+			code, regexp.QuoteMeta(http.StatusText(code)), code))
+	}
+	return patterns
+}
+
+// CheckDuplicateSample - call CheckDuplicateSample with retries
+func (c *RetryClient) CheckDuplicateSample(ctx context.Context, sha1List []string, days int) (result []string, err error) {
+	err = c.retry(ctx, "check duplicate sample", func() error {
+		result, err = c.ClientInterace.CheckDuplicateSample(ctx, sha1List, days)
+		return err
+	})
+	return
+}
+
+// UploadSample - call UploadSample with retries
+func (c *RetryClient) UploadSample(ctx context.Context, filePath, sha1 string) error {
+	return c.retry(ctx, "upload sample", func() error {
+		return c.ClientInterace.UploadSample(ctx, filePath, sha1)
+	})
+}
+
+// GetBriefReport - call GetBriefReport with retries
+func (c *RetryClient) GetBriefReport(ctx context.Context, sha1List []string) (result *ddan.BriefReports, err error) {
+	err = c.retry(ctx, "get brief report", func() error {
+		result, err = c.ClientInterace.GetBriefReport(ctx, sha1List)
+		return err
+	})
+	return
+}
+
+func (c *RetryClient) retry(ctx context.Context, name string, call func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= c.attempts || !c.Retryable(err) {
+			return err
+		}
+		delay := c.Delay(attempt)
+		log.Printf("%s: attempt %d of %d failed: %v. Retry in %v", name, attempt, c.attempts, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// Delay - return delay before given retry attempt
+func (c *RetryClient) Delay(attempt int) time.Duration {
+	delay := c.backoff
+	for i := 1; i < attempt && delay < c.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	if c.jitter > 0 {
+		spread := float64(delay) * c.jitter
+		delay += time.Duration(spread * (2*rand.Float64() - 1)) //nolint
+	}
+	return delay
+}
+
+// Retryable - return whenever error is transient and call should be retried
+func (c *RetryClient) Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// Certificate, DNS and other network errors that are not timeouts would
+	// not go away on retry
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	for _, each := range c.statuses {
+		if each.MatchString(err.Error()) {
+			return true
+		}
+	}
+	message := strings.ToLower(err.Error())
+	for _, each := range c.errors {
+		if strings.Contains(message, strings.ToLower(each)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+retry_test.go - tests for RetryClient
+
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+type failingClient struct {
+	ddan.ClientInterace
+	failures int
+	calls    int
+	err      error
+}
+
+func (c *failingClient) UploadSample(ctx context.Context, filePath, sha1 string) error {
+	c.calls++
+	if c.calls <= c.failures {
+		return c.err
+	}
+	return nil
+}
+
+func TestRetryClientTransient(t *testing.T) {
+	client := &failingClient{failures: 2, err: errors.New("502 Bad Gateway")}
+	retryClient := NewRetryClient(client).
+		SetAttempts(3).
+		SetBackoff(time.Millisecond, 2*time.Millisecond)
+	err := retryClient.UploadSample(context.Background(), "path", "sha1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 3 {
+		t.Errorf("Expected 3 calls, but got %d", client.calls)
+	}
+}
+
+func TestRetryClientPermanent(t *testing.T) {
+	client := &failingClient{failures: 2, err: errors.New("403 Forbidden")}
+	retryClient := NewRetryClient(client).
+		SetAttempts(3).
+		SetBackoff(time.Millisecond, 2*time.Millisecond)
+	err := retryClient.UploadSample(context.Background(), "path", "sha1")
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if client.calls != 1 {
+		t.Errorf("Expected 1 call, but got %d", client.calls)
+	}
+}
+
+func TestRetryClientDelay(t *testing.T) {
+	retryClient := NewRetryClient(nil).
+		SetBackoff(time.Second, 5*time.Second).
+		SetJitter(0)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, each := range expected {
+		actual := retryClient.Delay(i + 1)
+		if actual != each {
+			t.Errorf("Attempt %d: expected %v, but got %v", i+1, each, actual)
+		}
+	}
+}
+
+func TestRetryClientRetryable(t *testing.T) {
+	retryClient := NewRetryClient(nil)
+	testCases := map[string]bool{
+		"502 Bad Gateway":                                           true,
+		"upload sample: 503 Service Unavailable":                    true,
+		"unexpected status code: 504":                               true,
+		"status 502":                                                true,
+		"read tcp: connection reset by peer":                        true,
+		"403 Forbidden":                                             false,
+		"a9993e364706816aba3e25717850c26c9cd0d89d: 404":             false,
+		"upload /builds/503/app-5024.bin: 400 Bad Request":          false,
+		"sample 5039e364706816aba3e25717850c26c9cd0d89d: not found": false,
+	}
+	for message, expected := range testCases {
+		actual := retryClient.Retryable(errors.New(message))
+		if actual != expected {
+			t.Errorf("%s: expected %v, but got %v", message, expected, actual)
+		}
+	}
+}
+
+func TestRetryClientRetryableNetError(t *testing.T) {
+	retryClient := NewRetryClient(nil)
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"timeout", &net.DNSError{Err: "i/o timeout", Name: "analyzer", IsTimeout: true}, true},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"no such host", &net.DNSError{Err: "no such host", Name: "analyzer", IsNotFound: true}, false},
+		{"certificate", x509.UnknownAuthorityError{}, false},
+		{"scheme", errors.New(`unsupported protocol scheme "ftp"`), false},
+	}
+	for _, tc := range testCases {
+		err := &url.Error{Op: "Post", URL: "https://analyzer/web_service/sample_upload/", Err: tc.err}
+		actual := retryClient.Retryable(err)
+		if actual != tc.expected {
+			t.Errorf("%s: expected %v, but got %v", tc.name, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+s3.go - check objects of S3 compatible object storage
+
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrS3 = errors.New("S3 error")
+
+// emptyPayloadHash - SHA256 of empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Client - minimal client for S3 compatible object storage
+type S3Client struct {
+	endpoint  *url.URL
+	region    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+	now       func() time.Time
+}
+
+// S3Object - object found by ListObjects
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// NewS3Client - create client for given endpoint, e.g. https://minio:9000
+func NewS3Client(endpoint string) (*S3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("S3 endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("S3 endpoint %s: %w: scheme should be http or https", endpoint, ErrS3)
+	}
+	return &S3Client{
+		endpoint:  u,
+		region:    "us-east-1",
+		pathStyle: true,
+		client:    http.DefaultClient,
+		now:       time.Now,
+	}, nil
+}
+
+// SetRegion - set region used for requests signing
+func (c *S3Client) SetRegion(region string) *S3Client {
+	if region != "" {
+		c.region = region
+	}
+	return c
+}
+
+// SetCredentials - set access key and secret key. Without credentials requests are anonymous
+func (c *S3Client) SetCredentials(accessKey, secretKey string) *S3Client {
+	c.accessKey = accessKey
+	c.secretKey = secretKey
+	return c
+}
+
+// SetPathStyle - use bucket name in URL path (default, required by MinIO) or in host name
+func (c *S3Client) SetPathStyle(pathStyle bool) *S3Client {
+	c.pathStyle = pathStyle
+	return c
+}
+
+// SetIgnoreTLSError - do not check TLS certificate of the endpoint
+func (c *S3Client) SetIgnoreTLSError(ignoreTLSError bool) *S3Client {
+	if ignoreTLSError {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint
+		c.client = &http.Client{Transport: transport}
+	}
+	return c
+}
+
+// ListObjects - call function for each object with given prefix
+func (c *S3Client) ListObjects(ctx context.Context, bucket, prefix string, object func(S3Object) error) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		response, err := c.do(ctx, bucket, "", query)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		var result struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+		}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		for _, each := range result.Contents {
+			if err := object(S3Object(each)); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// GetObject - return object contents and its attributes
+func (c *S3Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, S3Object, error) {
+	object := S3Object{Key: key}
+	response, err := c.do(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, object, fmt.Errorf("get object %s: %w", key, err)
+	}
+	object.Size = response.ContentLength
+	object.LastModified, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+	return response.Body, object, nil
+}
+
+func (c *S3Client) do(ctx context.Context, bucket, key string, query url.Values) (*http.Response, error) {
+	u := *c.endpoint
+	objectPath := "/" + key
+	if c.pathStyle {
+		objectPath = "/" + bucket + objectPath
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + objectPath
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(request, emptyPayloadHash)
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		var s3Error struct {
+			Code    string
+			Message string
+		}
+		_ = xml.NewDecoder(io.LimitReader(response.Body, 1<<16)).Decode(&s3Error)
+		return nil, fmt.Errorf("%w: %s: %s %s", ErrS3, response.Status, s3Error.Code, s3Error.Message)
+	}
+	return response, nil
+}
+
+// sign - add AWS Signature Version 4 headers to request
+func (c *S3Client) sign(request *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	if c.accessKey == "" {
+		return
+	}
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	key := []byte("AWS4" + c.secretKey)
+	for _, each := range []string{date, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, each)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery - query string sorted by key and encoded as required by signature
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape - percent encode all characters except unreserved ones. Slash is
+// encoded only if encodeSlash is set
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// S3FS - bucket as file system. Names are object keys
+type S3FS struct {
+	ctx    context.Context
+	client *S3Client
+	bucket string
+}
+
+// NewS3FS - create file system for the bucket. Context is used for all requests
+func NewS3FS(ctx context.Context, client *S3Client, bucket string) *S3FS {
+	return &S3FS{ctx: ctx, client: client, bucket: bucket}
+}
+
+// Open - start reading of the object
+func (s *S3FS) Open(name string) (fs.File, error) {
+	body, object, err := s.client.GetObject(s.ctx, s.bucket, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &s3File{ReadCloser: body, info: s3FileInfo{object}}, nil
+}
+
+type s3File struct {
+	io.ReadCloser
+	info s3FileInfo
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// s3FileInfo - object attributes as fs.FileInfo
+type s3FileInfo struct {
+	object S3Object
+}
+
+func (i s3FileInfo) Name() string       { return path.Base(i.object.Key) }
+func (i s3FileInfo) Size() int64        { return i.object.Size }
+func (i s3FileInfo) Mode() fs.FileMode  { return 0o444 }
+func (i s3FileInfo) ModTime() time.Time { return i.object.LastModified }
+func (i s3FileInfo) IsDir() bool        { return false }
+func (i s3FileInfo) Sys() interface{}   { return nil }
+
+// S3Source - check all objects of the bucket with given prefix. Each object is
+// downloaded once to temporary file. File paths are s3://<bucket>/<key>
+type S3Source struct {
+	client *S3Client
+	bucket string
+	prefix string
+}
+
+// NewS3Source - create source for bucket objects with given prefix
+func NewS3Source(client *S3Client, bucket, prefix string) *S3Source {
+	return &S3Source{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Scan - list objects and pass them to application
+func (s *S3Source) Scan(ctx context.Context, a *Application) error {
+	log.Printf("Process bucket: %s/%s", s.bucket, s.prefix)
+	fsys := NewS3FS(ctx, s.client, s.bucket)
+	err := s.client.ListObjects(ctx, s.bucket, s.prefix, func(object S3Object) error {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		if strings.HasSuffix(object.Key, "/") || a.ShouldSkipFolder(object.Key) {
+			return nil
+		}
+		a.AddFile(NewFSFile(fsys, object.Key, "s3://"+s.bucket+"/"+object.Key, s3FileInfo{object}))
+		return nil
+	})
+	if err != nil {
+		if ctxErr := ContextError(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+func (s *S3Source) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
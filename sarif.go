@@ -0,0 +1,149 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+sarif.go - SARIF 2.1.0 report output
+
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifToolURI = "https://github.com/mpkondrashin/cia"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+var sarifRuleDescriptions = map[string]string{
+	"highRisk":    "File is rated as high risk by Analyzer",
+	"mediumRisk":  "File is rated as medium risk by Analyzer",
+	"lowRisk":     "File is rated as low risk by Analyzer",
+	"error":       "Analyzer failed to check file",
+	"unscannable": "File type is not supported by Analyzer",
+	"timeout":     "Analyzer did not complete file analysis in time",
+	"bigFile":     "File is bigger than maximum file size",
+	"unknown":     "File is unknown to Analyzer and was not uploaded in hash only mode",
+}
+
+var sarifLevels = map[string]string{
+	"highRisk":   "error",
+	"mediumRisk": "error",
+	"lowRisk":    "warning",
+}
+
+// WriteSARIF - write inadmissible files as SARIF 2.1.0 log
+func (r *Report) WriteSARIF(w io.Writer) error {
+	rules := make([]sarifRule, 0, len(VerdictList))
+	for _, verdict := range VerdictList {
+		rules = append(rules, sarifRule{
+			ID:               verdict,
+			ShortDescription: sarifMessage{Text: sarifRuleDescriptions[verdict]},
+		})
+	}
+	results := make([]sarifResult, 0)
+	for _, record := range r.Inadmissible() {
+		level, ok := sarifLevels[record.Verdict]
+		if !ok {
+			level = "warning"
+		}
+		results = append(results, sarifResult{
+			RuleID:  record.Verdict,
+			Level:   level,
+			Message: sarifMessage{Text: sarifResultMessage(record)},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(record.Path)},
+				},
+			}},
+			Properties: map[string]string{
+				"sha1":   record.SHA1,
+				"mime":   record.MIME,
+				"status": record.Status,
+				"risk":   record.Risk,
+			},
+		})
+	}
+	log := sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "cia",
+				InformationURI: sarifToolURI,
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+func sarifResultMessage(record Record) string {
+	message := fmt.Sprintf("%s: %s", record.Verdict, record.Path)
+	if record.Risk != "" {
+		message += fmt.Sprintf(" (%s, %s)", record.Status, record.Risk)
+	}
+	if record.Error != "" {
+		message += ": " + record.Error
+	}
+	return message
+}
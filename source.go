@@ -0,0 +1,101 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+source.go - sources of files to check
+
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// Source - provides files to check to the application
+type Source interface {
+	Scan(ctx context.Context, a *Application) error
+}
+
+// FolderSource - recursively check all files in folder
+type FolderSource string
+
+// Scan - walk folder
+func (s FolderSource) Scan(ctx context.Context, a *Application) error {
+	return a.WalkFolder(ctx, string(s))
+}
+
+// ListSource - check files listed in text file one path per line
+type ListSource struct {
+	name  string
+	input io.Reader
+}
+
+// NewListSource - read list of files from given file. "-" means stdin
+func NewListSource(path string) *ListSource {
+	if path == "-" {
+		return &ListSource{name: "stdin", input: os.Stdin}
+	}
+	return &ListSource{name: path}
+}
+
+// NewListSourceFromReader - read list of files from reader
+func NewListSourceFromReader(name string, input io.Reader) *ListSource {
+	return &ListSource{name: name, input: input}
+}
+
+// Scan - check each file from the list. Folders are walked recursively.
+// Missing files (for example deleted ones in git diff output) are ignored
+func (s *ListSource) Scan(ctx context.Context, a *Application) error {
+	log.Printf("Process files list: %s", s.name)
+	input := s.input
+	if input == nil {
+		f, err := os.Open(s.name)
+		if err != nil {
+			return fmt.Errorf("files list: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		if err := ContextError(ctx); err != nil {
+			return err
+		}
+		path := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		if a.ShouldSkipFolder(path) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Ignore missing file: %s", path)
+				continue
+			}
+			a.Fail(NewFileWithInfo(path, info), err)
+			continue
+		}
+		if info.IsDir() {
+			if err := a.WalkFolder(ctx, path); err != nil {
+				return err
+			}
+			continue
+		}
+		a.AddFile(NewFileWithInfo(path, info))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("files list %s: %w", s.name, err)
+	}
+	return nil
+}
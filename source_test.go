@@ -0,0 +1,74 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+source_test.go - tests for files sources
+
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplicationSources(t *testing.T) {
+	baseFolder := "testing/sources"
+	prepairFolder(t, baseFolder)
+	client := newFakeClient()
+	report := NewReport()
+	app := NewApplication(client).
+		SetPause(1 * time.Millisecond).
+		SetReport(report).
+		SetSkipFolders([]string{filepath.Join(baseFolder, "folder", "high")})
+	app.SetPrescanJobs(2).SetSubmitJobs(2)
+	list := strings.Join([]string{
+		filepath.Join(baseFolder, "high_risk.txt"),
+		"",
+		filepath.Join(baseFolder, "missing.txt"),
+		filepath.Join(baseFolder, "folder", "high_risk.txt"),
+		filepath.Join(baseFolder, "folder", "medium_risk.txt"),
+	}, "\n")
+	err := app.Run(context.Background(),
+		FolderSource(filepath.Join(baseFolder, "folder")),
+		NewListSourceFromReader("list", strings.NewReader(list)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := report.Records()
+	if len(records) != 5 {
+		t.Errorf("Expected 5 records, but got %d", len(records))
+	}
+	for _, each := range records {
+		if strings.HasSuffix(each.Path, "missing.txt") {
+			t.Errorf("Missing file in report: %v", each)
+		}
+	}
+}
+
+func TestApplicationFailedSource(t *testing.T) {
+	baseFolder := "testing/failed_source"
+	prepairFolder(t, baseFolder)
+	client := newFakeClient()
+	report := NewReport()
+	app := NewApplication(client).
+		SetPause(1 * time.Millisecond).
+		SetReport(report)
+	app.SetPrescanJobs(2).SetSubmitJobs(2)
+	err := app.Run(context.Background(),
+		FolderSource(filepath.Join(baseFolder, "missing")),
+		FolderSource(baseFolder),
+	)
+	if !errors.Is(err, ErrSourceFailed) {
+		t.Errorf("Expected %v, but got %v", ErrSourceFailed, err)
+	}
+	if records := report.Records(); len(records) != 8 {
+		t.Errorf("Expected 8 records, but got %d", len(records))
+	}
+}
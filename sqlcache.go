@@ -0,0 +1,230 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+sqlcache.go - analysis results stored in SQL database
+
+*/
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+var ErrSQLCache = errors.New("SQL cache")
+
+// SQL cache dialects
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// sqlVerdictsTable - table of verdicts stored by CIA
+const sqlVerdictsTable = "cia_verdicts"
+
+// cacheTableQueries - queries to find ddan cache table by its sha1, status and
+// risk_level columns
+var cacheTableQueries = map[string]string{
+	DialectPostgres: `SELECT table_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name <> '` + sqlVerdictsTable + `'
+		AND column_name IN ('sha1', 'status', 'risk_level')
+		GROUP BY table_name HAVING COUNT(DISTINCT column_name) = 3 ORDER BY table_name`,
+	DialectSQLite: `SELECT m.name FROM sqlite_master m JOIN pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name <> '` + sqlVerdictsTable + `'
+		AND p.name IN ('sha1', 'status', 'risk_level')
+		GROUP BY m.name HAVING COUNT(DISTINCT p.name) = 3 ORDER BY m.name`,
+}
+
+// SQLCache - verdicts stored in cia_verdicts table of PostgreSQL or SQLite
+// database. Cache table created by ddan library in the same database is only
+// read for samples that cia_verdicts table does not have. Verdicts found there
+// have no time. Deleted samples of ddan table are marked as deleted in
+// cia_verdicts table
+type SQLCache struct {
+	db    *sql.DB
+	table string
+}
+
+var _ VerdictCache = &SQLCache{}
+
+// OpenSQLCache - prepare database using ddan library and open cache in it
+func OpenSQLCache(db *sql.DB, dbURL, dialect string) (*SQLCache, error) {
+	if _, err := ddan.NewCache(db, dbURL); err != nil {
+		return nil, err
+	}
+	return NewSQLCache(db, dialect)
+}
+
+// NewSQLCache - create cia_verdicts table if needed and find ddan cache table.
+// Cache works without ddan table if it is not found
+func NewSQLCache(db *sql.DB, dialect string) (*SQLCache, error) {
+	query, ok := cacheTableQueries[dialect]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported database %s", ErrSQLCache, dialect)
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + sqlVerdictsTable + ` (
+		sha1 VARCHAR(40) PRIMARY KEY,
+		status INTEGER NOT NULL,
+		risk_level INTEGER NOT NULL,
+		verdict_time BIGINT,
+		author TEXT NOT NULL DEFAULT '',
+		note TEXT NOT NULL DEFAULT '',
+		deleted BOOLEAN NOT NULL DEFAULT FALSE)`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrSQLCache, sqlVerdictsTable, err)
+	}
+	var table string
+	err = db.QueryRow(query).Scan(&table)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &SQLCache{db: db}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSQLCache, err)
+	}
+	return &SQLCache{
+		db:    db,
+		table: `"` + strings.ReplaceAll(table, `"`, `""`) + `"`,
+	}, nil
+}
+
+// sha1Variants - lower and upper case forms of SHA1. Samples of ddan table are
+// looked up by both of them, so index on sha1 column is used whatever case ddan
+// library stores it in
+func sha1Variants(sha1 string) (string, string) {
+	return strings.ToLower(sha1), strings.ToUpper(sha1)
+}
+
+// scanVerdict - read sha1, status, risk_level, verdict_time, author and note
+// columns
+func scanVerdict(row interface{ Scan(...interface{}) error }) (Verdict, error) {
+	var verdict Verdict
+	var verdictTime sql.NullInt64
+	var author, note sql.NullString
+	err := row.Scan(&verdict.SHA1, &verdict.Status, &verdict.RiskLevel, &verdictTime, &author, &note)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if verdictTime.Valid {
+		verdict.Time = time.Unix(0, verdictTime.Int64).UTC()
+	}
+	verdict.Author = author.String
+	verdict.Note = note.String
+	return verdict, nil
+}
+
+// Get - return verdict for sample
+func (c *SQLCache) Get(sha1 string) (Verdict, bool, error) {
+	lower, upper := sha1Variants(sha1)
+	var deleted bool
+	var verdictTime sql.NullInt64
+	verdict := Verdict{SHA1: lower}
+	err := c.db.QueryRow(`SELECT status, risk_level, verdict_time, author, note, deleted FROM `+sqlVerdictsTable+`
+		WHERE sha1 = $1`, lower).Scan(&verdict.Status, &verdict.RiskLevel, &verdictTime, &verdict.Author, &verdict.Note, &deleted)
+	if err == nil {
+		if verdictTime.Valid {
+			verdict.Time = time.Unix(0, verdictTime.Int64).UTC()
+		}
+		return verdict, !deleted, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Verdict{}, false, fmt.Errorf("%w: get %s: %v", ErrSQLCache, sha1, err)
+	}
+	if c.table == "" {
+		return Verdict{}, false, nil
+	}
+	err = c.db.QueryRow(`SELECT status, risk_level FROM `+c.table+` WHERE sha1 IN ($1, $2)`, lower, upper).
+		Scan(&verdict.Status, &verdict.RiskLevel)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Verdict{}, false, nil
+	}
+	if err != nil {
+		return Verdict{}, false, fmt.Errorf("%w: get %s: %v", ErrSQLCache, sha1, err)
+	}
+	return verdict, true, nil
+}
+
+// Put - store verdict
+func (c *SQLCache) Put(verdict Verdict) error {
+	verdictTime := sql.NullInt64{Int64: verdict.Time.UnixNano(), Valid: !verdict.Time.IsZero()}
+	_, err := c.db.Exec(`INSERT INTO `+sqlVerdictsTable+` (sha1, status, risk_level, verdict_time, author, note, deleted)
+		VALUES ($1, $2, $3, $4, $5, $6, FALSE)
+		ON CONFLICT (sha1) DO UPDATE SET status = excluded.status, risk_level = excluded.risk_level,
+		verdict_time = excluded.verdict_time, author = excluded.author, note = excluded.note, deleted = FALSE`,
+		strings.ToLower(verdict.SHA1), int(verdict.Status), int(verdict.RiskLevel), verdictTime, verdict.Author, verdict.Note)
+	if err != nil {
+		return fmt.Errorf("%w: put %s: %v", ErrSQLCache, verdict.SHA1, err)
+	}
+	return nil
+}
+
+// Delete - remove verdict. Sample that ddan table has is marked as deleted
+func (c *SQLCache) Delete(sha1 string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w: delete %s: %v", ErrSQLCache, sha1, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	lower, upper := sha1Variants(sha1)
+	if _, err := tx.Exec(`DELETE FROM `+sqlVerdictsTable+` WHERE sha1 = $1`, lower); err != nil {
+		return fmt.Errorf("%w: delete %s: %v", ErrSQLCache, sha1, err)
+	}
+	if c.table != "" {
+		_, err := tx.Exec(`INSERT INTO `+sqlVerdictsTable+` (sha1, status, risk_level, deleted)
+			SELECT $1, 0, 0, TRUE WHERE EXISTS (SELECT 1 FROM `+c.table+` WHERE sha1 IN ($2, $3))`,
+			lower, lower, upper)
+		if err != nil {
+			return fmt.Errorf("%w: delete %s: %v", ErrSQLCache, sha1, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: delete %s: %v", ErrSQLCache, sha1, err)
+	}
+	return nil
+}
+
+// Range - call function for each verdict. Verdicts are read before the first
+// call, so function can change the cache
+func (c *SQLCache) Range(f func(Verdict) error) error {
+	query := `SELECT sha1, status, risk_level, verdict_time, author, note FROM ` + sqlVerdictsTable + ` WHERE NOT deleted`
+	if c.table != "" {
+		query += ` UNION ALL SELECT LOWER(t.sha1), t.status, t.risk_level, NULL, NULL, NULL FROM ` + c.table + ` t
+			WHERE NOT EXISTS (SELECT 1 FROM ` + sqlVerdictsTable + ` v WHERE v.sha1 = LOWER(t.sha1))`
+	}
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSQLCache, err)
+	}
+	var verdicts []Verdict
+	for rows.Next() {
+		verdict, err := scanVerdict(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("%w: %v", ErrSQLCache, err)
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSQLCache, err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSQLCache, err)
+	}
+	for _, verdict := range verdicts {
+		if err := f(verdict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close - close database
+func (c *SQLCache) Close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,85 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+sqlcache_test.go - tests for SQLCache
+
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+// openTestSQLCache - create SQLite database with table like ddan cache one
+func openTestSQLCache(t *testing.T) *SQLCache {
+	t.Helper()
+	db, _, err := openSQLite(filepath.Join(t.TempDir(), "cia.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("CREATE TABLE samples (sha1 VARCHAR(40) PRIMARY KEY, status INTEGER, risk_level INTEGER)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewSQLCache(db, DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestSQLCache(t *testing.T) {
+	cache := openTestSQLCache(t)
+	defer cache.Close()
+	testVerdictCache(t, cache)
+	var count int
+	if err := cache.db.QueryRow("SELECT COUNT(*) FROM samples").Scan(&count); err != nil || count != 0 {
+		t.Errorf("Verdict is written to ddan table: %d rows, error %v", count, err)
+	}
+	_, err := cache.db.Exec("INSERT INTO samples (sha1, status, risk_level) VALUES ($1, $2, $3)",
+		"DDD", int(ddan.StatusDone), int(ddan.RatingLowRisk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdict, found, err := cache.Get("ddd")
+	if err != nil || !found {
+		t.Fatalf("Get: found %v, error %v", found, err)
+	}
+	if verdict.SHA1 != "ddd" || verdict.RiskLevel != ddan.RatingLowRisk || !verdict.Time.IsZero() || verdict.Manual() {
+		t.Errorf("Wrong verdict stored by ddan: %v", verdict)
+	}
+	var verdicts []Verdict
+	if err := cache.Range(func(verdict Verdict) error {
+		verdicts = append(verdicts, verdict)
+		return nil
+	}); err != nil || len(verdicts) != 2 {
+		t.Errorf("Range: %v, error %v", verdicts, err)
+	}
+	if err := cache.Delete("ddd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := cache.Get("ddd"); found {
+		t.Errorf("Deleted verdict is found")
+	}
+	if err := cache.db.QueryRow("SELECT COUNT(*) FROM samples").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Row of ddan table is changed: %d rows, error %v", count, err)
+	}
+	if err := cache.Put(Verdict{SHA1: "ddd", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk}); err != nil {
+		t.Fatal(err)
+	}
+	verdict, found, err = cache.Get("ddd")
+	if err != nil || !found || verdict.RiskLevel != ddan.RatingHighRisk {
+		t.Errorf("Verdict is not stored after delete: %v, found %v, error %v", verdict, found, err)
+	}
+}
+
+func TestCacheAdminSQLite(t *testing.T) {
+	cache := openTestSQLCache(t)
+	defer cache.Close()
+	testCacheAdmin(t, cache)
+}
@@ -0,0 +1,54 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+sqlite.go - setup SQLite database file used for caching
+
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteBusyTimeout - time in milliseconds to wait for database lock
+const sqliteBusyTimeout = 10000
+
+func setupSQLiteCache() (*sql.DB, string, error) {
+	viper.SetDefault("cache.path", "cia_cache.db")
+	path := viper.GetString("cache.path")
+	db, dsn, err := openSQLite(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return db, dsn, nil
+}
+
+// openSQLite - open or create SQLite database file. Database is accessed using
+// single connection to avoid lock errors on concurrent writes
+func openSQLite(path string) (*sql.DB, string, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, "", err
+		}
+	}
+	dsn := fmt.Sprintf("file:%s?_pragma=%s", path, url.QueryEscape(fmt.Sprintf("busy_timeout(%d)", sqliteBusyTimeout)))
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, "", fmt.Errorf("Open: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+	return db, dsn, nil
+}
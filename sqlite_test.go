@@ -0,0 +1,103 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+sqlite_test.go - tests for SQLite cache
+
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+func TestOpenSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "cia.db")
+	db, _, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE samples (sha1 TEXT PRIMARY KEY, status INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO samples (sha1, status) VALUES ($1, $2)", "abc", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, _, err = openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var status int
+	if err := db.QueryRow("SELECT status FROM samples WHERE sha1 = $1", "abc").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != 4 {
+		t.Errorf("Expected 4, but got %d", status)
+	}
+}
+
+func TestDDANSQLite(t *testing.T) {
+	baseFolder := "testing/sqlite"
+	prepairFolder(t, baseFolder)
+	db, dsn, err := openSQLite(filepath.Join(t.TempDir(), "cia.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cache, err := ddan.NewCache(db, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer, stop := analyzerMockup(t, ddan.NewCachedClient("productName", "hostname", cache))
+	defer stop()
+	for i := 0; i < 2; i++ {
+		report := NewReport()
+		app := NewApplication(analyzer).
+			SetPause(1 * time.Millisecond).
+			SetReport(report)
+		err := app.Run(context.Background(), FolderSource(baseFolder))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records := report.Records(); len(records) == 0 {
+			t.Errorf("%d: no files checked", i)
+		}
+	}
+	var rows int
+	tables, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for tables.Next() {
+		var name string
+		if err := tables.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := tables.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		var count int
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %q", name)).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		rows += count
+	}
+	if rows == 0 {
+		t.Errorf("No verdicts stored in %v tables", names)
+	}
+}
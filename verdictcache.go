@@ -0,0 +1,291 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+verdictcache.go - cache of analysis results independent of storage
+
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+// Verdict - cached result of sample analysis
+type Verdict struct {
+	SHA1      string          `json:"sha1"`
+	Status    ddan.StatusCode `json:"status"`
+	RiskLevel ddan.Rating     `json:"risk_level"`
+	Time      time.Time       `json:"time"`
+	Author    string          `json:"author,omitempty"`
+	Note      string          `json:"note,omitempty"`
+}
+
+// Manual - return true if verdict was set manually
+func (v Verdict) Manual() bool {
+	return v.Author != "" || v.Note != ""
+}
+
+// VerdictCache - storage of analysis results by SHA1
+type VerdictCache interface {
+	Get(sha1 string) (Verdict, bool, error)
+	Put(verdict Verdict) error
+	Delete(sha1 string) error
+	Range(func(Verdict) error) error
+	Close() error
+}
+
+// CacheAgeReporter - client that can tell age of cached verdict used for sample
+type CacheAgeReporter interface {
+	CacheAge(sha1 string) (time.Duration, bool)
+}
+
+// VerdictCachedClient - Analyzer client that returns cached results without
+// contacting Analyzer and stores new final results in cache. Verdicts older than
+// expiry age for their risk level are checked again
+type VerdictCachedClient struct {
+	ddan.ClientInterace
+	cache  VerdictCache
+	expiry map[ddan.Rating]time.Duration
+	now    func() time.Time
+	start  time.Time
+}
+
+var (
+	_ ddan.ClientInterace = &VerdictCachedClient{}
+	_ CacheAgeReporter    = &VerdictCachedClient{}
+)
+
+// NewVerdictCachedClient - wrap client to use given cache
+func NewVerdictCachedClient(client ddan.ClientInterace, cache VerdictCache) *VerdictCachedClient {
+	return &VerdictCachedClient{
+		ClientInterace: client,
+		cache:          cache,
+		expiry:         make(map[ddan.Rating]time.Duration),
+		now:            time.Now,
+		start:          time.Now(),
+	}
+}
+
+// SetExpiry - set age after which cached verdict with given risk level is checked
+// again. Zero age means verdict never expires
+func (c *VerdictCachedClient) SetExpiry(riskLevel ddan.Rating, age time.Duration) *VerdictCachedClient {
+	if age <= 0 {
+		delete(c.expiry, riskLevel)
+		return c
+	}
+	c.expiry[riskLevel] = age
+	return c
+}
+
+// CacheAge - return age of cached verdict for sample if it was stored before
+// this client was created, i.e. it was not received during current scan. Age of
+// verdicts without time is unknown
+func (c *VerdictCachedClient) CacheAge(sha1 string) (time.Duration, bool) {
+	verdict, found, err := c.cache.Get(strings.ToLower(sha1))
+	if err != nil || !found || verdict.Time.IsZero() || !verdict.Time.Before(c.start) || c.expired(verdict) {
+		return 0, false
+	}
+	return c.now().Sub(verdict.Time), true
+}
+
+// CheckDuplicateSample - return cached samples as already known to Analyzer and
+// check the rest. Samples with expired verdicts are considered known only if Analyzer
+// got them within expiry period, so others are submitted again
+func (c *VerdictCachedClient) CheckDuplicateSample(ctx context.Context, sha1List []string, days int) ([]string, error) {
+	cached, expired, unknown, err := c.split(sha1List)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(sha1List))
+	for sha1 := range cached {
+		result = append(result, sha1)
+	}
+	byDays := make(map[int][]string)
+	for _, sha1 := range unknown {
+		checkDays := days
+		if verdict, ok := expired[strings.ToLower(sha1)]; ok {
+			checkDays = c.expiryDays(verdict, days)
+			log.Printf("Cached verdict for %s expired: %s", sha1, verdict.Time.Format(time.RFC3339))
+		}
+		byDays[checkDays] = append(byDays[checkDays], sha1)
+	}
+	for checkDays, list := range byDays {
+		duplicates, err := c.ClientInterace.CheckDuplicateSample(ctx, list, checkDays)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, duplicates...)
+	}
+	return result, nil
+}
+
+// GetBriefReport - return cached results and request the rest from Analyzer.
+// Final results are stored in cache
+func (c *VerdictCachedClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	cached, _, unknown, err := c.split(sha1List)
+	if err != nil {
+		return nil, err
+	}
+	result := &ddan.BriefReports{}
+	fetched := make(map[string]ddan.BriefReport)
+	if len(unknown) > 0 {
+		reports, err := c.ClientInterace.GetBriefReport(ctx, unknown)
+		if err != nil {
+			return nil, err
+		}
+		if len(reports.Reports) != len(unknown) {
+			return nil, fmt.Errorf("got %d brief reports for %d samples: %w", len(reports.Reports), len(unknown), ErrNoReport)
+		}
+		*result = *reports
+		for i, report := range reports.Reports {
+			fetched[strings.ToLower(unknown[i])] = report
+		}
+	}
+	result.Reports = make([]ddan.BriefReport, 0, len(sha1List))
+	for _, sha1 := range sha1List {
+		if verdict, ok := cached[strings.ToLower(sha1)]; ok {
+			result.Reports = append(result.Reports, ddan.BriefReport{
+				SampleStatus: verdict.Status,
+				RiskLevel:    verdict.RiskLevel,
+			})
+			continue
+		}
+		report, ok := fetched[strings.ToLower(sha1)]
+		if !ok {
+			continue
+		}
+		result.Reports = append(result.Reports, report)
+		if report.SampleStatus != ddan.StatusDone {
+			continue
+		}
+		err := c.cache.Put(Verdict{
+			SHA1:      strings.ToLower(sha1),
+			Status:    report.SampleStatus,
+			RiskLevel: report.RiskLevel,
+			Time:      c.now(),
+		})
+		if err != nil {
+			log.Printf("Cache %s: %v", sha1, err)
+		}
+	}
+	return result, nil
+}
+
+// Close - close cache
+func (c *VerdictCachedClient) Close() error {
+	return c.cache.Close()
+}
+
+// split - divide samples to cached and unknown ones. Samples with expired verdicts
+// are returned both as unknown and in expired map
+func (c *VerdictCachedClient) split(sha1List []string) (map[string]Verdict, map[string]Verdict, []string, error) {
+	cached := make(map[string]Verdict)
+	expired := make(map[string]Verdict)
+	var unknown []string
+	for _, sha1 := range sha1List {
+		verdict, found, err := c.cache.Get(strings.ToLower(sha1))
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("cache: %w", err)
+		}
+		if !found {
+			unknown = append(unknown, sha1)
+			continue
+		}
+		if c.expired(verdict) {
+			expired[strings.ToLower(sha1)] = verdict
+			unknown = append(unknown, sha1)
+			continue
+		}
+		cached[strings.ToLower(sha1)] = verdict
+	}
+	return cached, expired, unknown, nil
+}
+
+// expired - return true if verdict is older than expiry age for its risk level.
+// Verdicts without time are considered old. Manual verdicts never expire
+func (c *VerdictCachedClient) expired(verdict Verdict) bool {
+	if verdict.Manual() {
+		return false
+	}
+	age, ok := c.expiry[verdict.RiskLevel]
+	return ok && c.now().Sub(verdict.Time) >= age
+}
+
+// expiryDays - return number of days to check duplicates for sample with expired verdict
+func (c *VerdictCachedClient) expiryDays(verdict Verdict, days int) int {
+	expiryDays := int(c.expiry[verdict.RiskLevel] / (24 * time.Hour))
+	if expiryDays < 1 {
+		expiryDays = 1
+	}
+	if days > 0 && days < expiryDays {
+		return days
+	}
+	return expiryDays
+}
+
+// MemoryCache - cache that lives only during the scan
+type MemoryCache struct {
+	mx       sync.RWMutex
+	verdicts map[string]Verdict
+}
+
+var _ VerdictCache = &MemoryCache{}
+
+// NewMemoryCache - create empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{verdicts: make(map[string]Verdict)}
+}
+
+// Get - return verdict for sample
+func (m *MemoryCache) Get(sha1 string) (Verdict, bool, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	verdict, found := m.verdicts[sha1]
+	return verdict, found, nil
+}
+
+// Put - store verdict
+func (m *MemoryCache) Put(verdict Verdict) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.verdicts[verdict.SHA1] = verdict
+	return nil
+}
+
+// Delete - remove verdict
+func (m *MemoryCache) Delete(sha1 string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.verdicts, sha1)
+	return nil
+}
+
+// Range - call function for each verdict
+func (m *MemoryCache) Range(f func(Verdict) error) error {
+	m.mx.RLock()
+	verdicts := make([]Verdict, 0, len(m.verdicts))
+	for _, verdict := range m.verdicts {
+		verdicts = append(verdicts, verdict)
+	}
+	m.mx.RUnlock()
+	for _, verdict := range verdicts {
+		if err := f(verdict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close - does nothing
+func (m *MemoryCache) Close() error {
+	return nil
+}
@@ -0,0 +1,240 @@
+/*
+
+Check It All (c) 2022 by Michael Kondrashin mkondrashin@gmail.com
+
+verdictcache_test.go - tests for verdict caches
+
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpkondrashin/ddan"
+)
+
+// countingClient - fake client that counts requested samples
+type countingClient struct {
+	*fakeClient
+	reports []string
+	days    []int
+}
+
+func (c *countingClient) CheckDuplicateSample(ctx context.Context, sha1List []string, days int) ([]string, error) {
+	c.mx.Lock()
+	c.days = append(c.days, days)
+	c.mx.Unlock()
+	return c.fakeClient.CheckDuplicateSample(ctx, sha1List, days)
+}
+
+func (c *countingClient) GetBriefReport(ctx context.Context, sha1List []string) (*ddan.BriefReports, error) {
+	c.mx.Lock()
+	c.reports = append(c.reports, sha1List...)
+	c.mx.Unlock()
+	result := &ddan.BriefReports{}
+	for _, sha1 := range sha1List {
+		report := ddan.BriefReport{SampleStatus: ddan.StatusDone, RiskLevel: ddan.RatingLowRisk}
+		if strings.HasPrefix(sha1, "busy") {
+			report.SampleStatus = ddan.StatusProcessing
+		}
+		result.Reports = append(result.Reports, report)
+	}
+	return result, nil
+}
+
+func testVerdictCache(t *testing.T, cache VerdictCache) {
+	t.Helper()
+	_, found, err := cache.Get("abc")
+	if err != nil || found {
+		t.Errorf("Empty cache: found %v, error %v", found, err)
+	}
+	verdict := Verdict{SHA1: "abc", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk, Time: time.Now().UTC()}
+	if err := cache.Put(verdict); err != nil {
+		t.Fatal(err)
+	}
+	actual, found, err := cache.Get("abc")
+	if err != nil || !found {
+		t.Fatalf("Get: found %v, error %v", found, err)
+	}
+	if actual.RiskLevel != verdict.RiskLevel || !actual.Time.Equal(verdict.Time) {
+		t.Errorf("Expected %v, but got %v", verdict, actual)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testVerdictCache(t, NewMemoryCache())
+}
+
+func TestFileCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "cia.jsonl")
+	cache, err := OpenFileCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testVerdictCache(t, cache)
+	if err := cache.Put(Verdict{SHA1: "abc", Status: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Put(Verdict{SHA1: "def"}); err == nil {
+		t.Errorf("No error for closed cache")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("Expected compacted file with 1 record, but got %d", lines)
+	}
+	if err := os.WriteFile(path, append(data, `{"sha1":"trunc`...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err = OpenFileCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	verdict, found, err := cache.Get("abc")
+	if err != nil || !found || verdict.RiskLevel != ddan.RatingNoRiskFound {
+		t.Errorf("Wrong verdict after reopen: %v, found %v, error %v", verdict, found, err)
+	}
+}
+
+func TestVerdictCachedClient(t *testing.T) {
+	client := &countingClient{fakeClient: newFakeClient()}
+	cache := NewMemoryCache()
+	if err := cache.Put(Verdict{SHA1: "cached", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk}); err != nil {
+		t.Fatal(err)
+	}
+	cachedClient := NewVerdictCachedClient(client, cache)
+	duplicates, err := cachedClient.CheckDuplicateSample(context.Background(), []string{"CACHED", "new"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 1 || duplicates[0] != "cached" {
+		t.Errorf("Wrong duplicates: %v", duplicates)
+	}
+	if len(client.duplicates) != 1 || len(client.duplicates[0]) != 1 {
+		t.Errorf("Cached sample is checked by Analyzer: %v", client.duplicates)
+	}
+	sha1List := []string{"new", "cached", "busy"}
+	reports, err := cachedClient.GetBriefReport(context.Background(), sha1List)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ddan.Rating{ddan.RatingLowRisk, ddan.RatingHighRisk, ddan.RatingLowRisk}
+	for i, report := range reports.Reports {
+		if report.RiskLevel != expected[i] {
+			t.Errorf("%s: expected %v, but got %v", sha1List[i], expected[i], report.RiskLevel)
+		}
+	}
+	if strings.Join(client.reports, ",") != "new,busy" {
+		t.Errorf("Wrong samples requested from Analyzer: %v", client.reports)
+	}
+	if _, found, _ := cache.Get("new"); !found {
+		t.Errorf("Final result is not cached")
+	}
+	if _, found, _ := cache.Get("busy"); found {
+		t.Errorf("Not final result is cached")
+	}
+}
+
+func TestVerdictCachedClientExpiry(t *testing.T) {
+	client := &countingClient{fakeClient: newFakeClient()}
+	cache := NewMemoryCache()
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	for _, verdict := range []Verdict{
+		{SHA1: "clean", Status: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound, Time: old},
+		{SHA1: "manual", Status: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound, Time: old, Author: "admin"},
+		{SHA1: "malware", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk, Time: old},
+		{SHA1: "legacy", Status: ddan.StatusDone, RiskLevel: ddan.RatingHighRisk},
+	} {
+		if err := cache.Put(verdict); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cachedClient := NewVerdictCachedClient(client, cache).
+		SetExpiry(ddan.RatingNoRiskFound, 30*24*time.Hour).
+		SetExpiry(ddan.RatingHighRisk, 0)
+	duplicates, err := cachedClient.CheckDuplicateSample(context.Background(), []string{"clean", "manual", "malware", "legacy"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 3 {
+		t.Errorf("Wrong duplicates: %v", duplicates)
+	}
+	if len(client.days) != 1 || client.days[0] != 30 || client.duplicates[0][0] != "clean" {
+		t.Errorf("Expired sample is not checked by Analyzer for 30 days: %v %v", client.duplicates, client.days)
+	}
+	if _, ok := cachedClient.CacheAge("clean"); ok {
+		t.Errorf("Expired verdict has cache age")
+	}
+	age, ok := cachedClient.CacheAge("malware")
+	if !ok || age < 40*24*time.Hour {
+		t.Errorf("Wrong cache age: %v %v", age, ok)
+	}
+	if _, ok := cachedClient.CacheAge("legacy"); ok {
+		t.Errorf("Verdict without time has cache age")
+	}
+
+	sha1List := []string{"clean", "malware"}
+	reports, err := cachedClient.GetBriefReport(context.Background(), sha1List)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ddan.Rating{ddan.RatingLowRisk, ddan.RatingHighRisk}
+	for i, report := range reports.Reports {
+		if report.RiskLevel != expected[i] {
+			t.Errorf("%s: expected %v, but got %v", sha1List[i], expected[i], report.RiskLevel)
+		}
+	}
+	if strings.Join(client.reports, ",") != "clean" {
+		t.Errorf("Wrong samples requested from Analyzer: %v", client.reports)
+	}
+	if _, ok := cachedClient.CacheAge("clean"); ok {
+		t.Errorf("Verdict received during scan has cache age")
+	}
+}
+
+func TestVerdictCachedClientDuplicateAge(t *testing.T) {
+	folder := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(folder, name), []byte("same"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sha1, err := NewFileInFolder(folder, "a.txt", nil).Sha1()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewMemoryCache()
+	verdict := Verdict{SHA1: sha1, Status: ddan.StatusDone, RiskLevel: ddan.RatingNoRiskFound, Time: time.Now().Add(-time.Hour)}
+	if err := cache.Put(verdict); err != nil {
+		t.Fatal(err)
+	}
+	report := NewReport()
+	app := NewApplication(NewVerdictCachedClient(newFakeClient(), cache)).
+		SetPause(1 * time.Millisecond).
+		SetReport(report)
+	app.SetPrescanJobs(1).SetSubmitJobs(1)
+	if err := app.Run(context.Background(), FolderSource(folder)); err != nil {
+		t.Fatal(err)
+	}
+	records := report.Records()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but got %v", records)
+	}
+	for _, record := range records {
+		if record.CacheAge != "1h0m0s" {
+			t.Errorf("%s: wrong cache age %q", record.Path, record.CacheAge)
+		}
+	}
+}